@@ -27,8 +27,14 @@ limitations under the License.
 package main
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 )
 
@@ -41,35 +47,402 @@ import (
 //var recType = []string{"ARTINV", "USER", "BID", "AUCREQ", "POSTTRAN", "OPENAUC", "CLAUC", "XFER", "VERIFY"}
 
 //////////////////////////////////////////////////////////////////////////////////////////////////
-// The following array holds the list of tables that should be created
-// The deploy/init deletes the tables and recreates them every time a deploy is invoked
+// Registry of the Object types. Each type declares once
+//     New             : a pointer to a new, empty Object of the type
+//     KeyFields       : the attributes that make up the key, in key order. Types keyed by
+//                       something else than their attributes give their number of Keys instead
+//     EmptyKeys       : the key attributes that may be empty, every other key part must be set
+//     Required        : the attributes that must be present when posted as a JSON document
+//     ChaincodeFields : the attributes set by the chaincode only. They are not taken from the
+//                       caller, a write keeps the ones of the stored Object
+//     Validators      : checks a posted or updated Object must pass
+//     BeforePost      : called before a post is written, with the stored Object or nil
+//     BeforeUpdate    : called before an update is written, with the stored Object
+//     ListView        : how a list of the Objects is returned, the Objects themselves if nil
+//     Owner           : the account a record belongs to, for the access policy
+//     Indexes         : the secondary indexes, see UpdateIndexes
+//     System          : kept by the chaincode itself, always enabled, see trace_config.go
+//     EnabledWith     : the type whose writes keep this one up to date, it is enabled with it
+//     Functions       : the chaincode functions generated for the type, see trace_registry.go
+// A new Object type needs its struct and an entry here, plus whatever its functions do
+// that the generated ones do not
 //////////////////////////////////////////////////////////////////////////////////////////////////
-var Objects = []string{"SkuTraceRecordObj", "SkuAuthenticationTraceRecordObj", "SkuBaseInfoObj", "SkuTransactionObj", "CertificationAccountInfoObj", "AccountInfoObj"}
+type ObjectType struct {
+	Name            string
+	New             func() interface{}
+	Keys            int
+	KeyFields       []string
+	EmptyKeys       []string
+	Required        []string
+	ChaincodeFields []string
+	Validators      []ObjectValidator
+	BeforePost      ObjectHook
+	BeforeUpdate    ObjectHook
+	ListView        func(stub shim.ChaincodeStubInterface, objects []interface{}) (interface{}, error)
+	Owner           RecordOwnerFunc
+	Indexes         map[string][]string
+	System          bool
+	EnabledWith     string
+	Functions       map[string]ObjectFunction
+}
+
+type ObjectValidator func(stub shim.ChaincodeStubInterface, object interface{}) error
+
+type ObjectHook func(stub shim.ChaincodeStubInterface, previous interface{}, object interface{}) error
+
+type RecordOwnerFunc func(stub shim.ChaincodeStubInterface, objectData []byte) (string, string, error)
+
+// A chaincode function generated for an Object type, and the event it emits
+type ObjectFunction struct {
+	Kind  string
+	Event string
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+// The registered Object types by name, built once by init
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+var objectTypeRegistry map[string]*ObjectType
+
+func init() {
+	objectTypeRegistry = registerObjectTypes()
+}
+
+func ObjectTypes() map[string]*ObjectType {
+	return objectTypeRegistry
+}
+
+// The registrations refer to handlers that look Object types up, they are
+// built in init rather than in the declaration of objectTypeRegistry
+func registerObjectTypes() map[string]*ObjectType {
+	ObjectMap := map[string]*ObjectType{
+		"SkuTraceRecordObj": {
+			New:             func() interface{} { return &SkuTraceRecordObj{} },
+			KeyFields:       []string{"TraceCode", "SkuId", "AddressHash", "StationType"},
+			Required:        []string{"SkuId", "AddressHash", "TraceCode", "StationType", "StationName", "TimeStamp"},
+			ChaincodeFields: []string{"Discontinuity"},
+			Validators:      []ObjectValidator{ValidateSkuTraceRecord},
+			BeforePost:      CheckStationContinuity,
+			BeforeUpdate:    CheckStationContinuity,
+			Owner:           SkuTraceRecordOwner,
+			Indexes:         map[string][]string{"SkuId": {"SkuId", "BatchNum"}, "BatchNum": {"BatchNum"}, "AddressHash": {"AddressHash"}, "ExpressNum": {"ExpressNum"}},
+			Functions: map[string]ObjectFunction{
+				"iPostSkuTraceRecord":               {OBJECT_POST, EVENT_TRACE_RECORD_POSTED},
+				"iUpdateSkuTraceRecord":             {OBJECT_PATCH, EVENT_TRACE_RECORD_UPDATED},
+				"qGetSkuTraceRecordListByTraceCode": {OBJECT_LIST, ""},
+			},
+		},
+		"SkuAuthenticationTraceRecordObj": {
+			New:        func() interface{} { return &SkuAuthenticationTraceRecordObj{} },
+			KeyFields:  []string{"TraceCode", "SkuId", "AddressHash", "CertificationBodyType"},
+			Required:   []string{"SkuId", "AddressHash", "TraceCode", "CertificationBodyType", "CertificationBodyName", "TimeStamp"},
+			Validators: []ObjectValidator{ValidateSkuAuthenticationTraceRecord},
+			ListView:   SkuAuthenticationListView,
+			Owner:      SkuAuthenticationTraceRecordOwner,
+			Indexes:    map[string][]string{"SkuId": {"SkuId", "BatchNum"}, "BatchNum": {"BatchNum"}, "AddressHash": {"AddressHash"}},
+			Functions: map[string]ObjectFunction{
+				"iPostSkuAuthenticationTraceRecord":          {OBJECT_POST, EVENT_AUTHENTICATION_RECORD_POSTED},
+				"iUpdateSkuAuthenticationTraceRecord":        {OBJECT_PATCH, EVENT_AUTHENTICATION_RECORD_UPDATED},
+				"qGetSkuAuthenticationRecordListByTraceCode": {OBJECT_LIST, ""},
+			},
+		},
+		"SkuBaseInfoObj": {
+			New:       func() interface{} { return &SkuBaseInfoObj{} },
+			KeyFields: []string{"TraceCode"},
+			Required:  []string{"SkuId", "TraceCode", "TimeStamp"},
+			Owner:     SkuBaseInfoOwner,
+			Indexes:   map[string][]string{"SkuId": {"SkuId", "BatchNum"}, "BatchNum": {"BatchNum"}, "AddressHash": {"AddressHash"}},
+			Functions: map[string]ObjectFunction{
+				"iPostSkuBaseInfo":           {OBJECT_POST, EVENT_BASE_INFO_POSTED},
+				"iPostTransactionId":         {OBJECT_POST, EVENT_BASE_INFO_POSTED},
+				"iUpdateSkuBaseInfo":         {OBJECT_REPLACE, EVENT_BASE_INFO_UPDATED},
+				"qGetSkuBaseInfoByTraceCode": {OBJECT_GET, ""},
+			},
+		},
+		"SkuTransactionObj": {
+			New:             func() interface{} { return &SkuTransactionObj{} },
+			KeyFields:       []string{"TraceCode", "SkuId", "OrderId", "TransType"},
+			Required:        []string{"OrderId", "SkuId", "TraceCode", "TransType", "AccountNo", "Num", "TransDate"},
+			ChaincodeFields: []string{"Revision"},
+			BeforePost:      PrepareSkuTransaction,
+			Owner:           SkuTransactionOwner,
+			Indexes:         map[string][]string{"SkuId": {"SkuId", "BatchNum"}, "BatchNum": {"BatchNum"}, "OrderId": {"OrderId"}, "AccountNo": {"AccountNo"}},
+			Functions: map[string]ObjectFunction{
+				"iPostSkuTransaction":               {OBJECT_POST, EVENT_TRANSACTION_POSTED},
+				"qGetSkuTransactionListByTraceCode": {OBJECT_LIST, ""},
+			},
+		},
+		"CertificationAccountInfoObj": {
+			New:             func() interface{} { return &CertificationAccountInfoObj{} },
+			KeyFields:       []string{"Name"},
+			Required:        []string{"Name", "AccountType", "PublicKey", "OrgName"},
+			ChaincodeFields: []string{"StatusChanges"},
+			Owner:           CertificationAccountInfoOwner,
+			Functions: map[string]ObjectFunction{
+				"iPostCertificationAccountInfo":             {OBJECT_POST, EVENT_CERTIFICATION_ACCOUNT_INFO_POSTED},
+				"iUpdateCertificationAccountInfo":           {OBJECT_REPLACE, EVENT_CERTIFICATION_ACCOUNT_INFO_UPDATED},
+				"qGetCertificationAccountInfoByAddressHash": {OBJECT_GET, ""},
+			},
+		},
+		"AccountInfoObj": {
+			New:          func() interface{} { return &AccountInfoObj{} },
+			KeyFields:    []string{"Name"},
+			Required:     []string{"Name", "AccountType", "PublicKey", "OrgName"},
+			BeforePost:   RecordAccountInfoKey,
+			BeforeUpdate: RecordAccountInfoKey,
+			Owner:        AccountInfoOwner,
+			Functions: map[string]ObjectFunction{
+				"iPostAccountInfo":             {OBJECT_POST, EVENT_ACCOUNT_INFO_POSTED},
+				"iUpdateAccountInfo":           {OBJECT_REPLACE, EVENT_ACCOUNT_INFO_UPDATED},
+				"qGetAccountInfoByAddressHash": {OBJECT_GET, ""},
+			},
+		},
+		"RecallObj": {
+			New:       func() interface{} { return &RecallObj{} },
+			KeyFields: []string{"RecallId"},
+			Required:  []string{"RecallId", "Reason", "IssuedBy"},
+		},
+		"SensorReadingObj": {
+			New:       func() interface{} { return &SensorReadingObj{} },
+			KeyFields: []string{"TraceCode", "StationName", "ReadAt", "SensorId"},
+			Required:  []string{"TraceCode", "SkuId", "StationName", "SensorId", "ReadAt"},
+		},
+		"SensorThresholdObj": {
+			New:       func() interface{} { return &SensorThresholdObj{} },
+			KeyFields: []string{"SkuId"},
+			Required:  []string{"SkuId"},
+		},
+		"AccessPolicyObj":    {Keys: 1, System: true},
+		"ChannelSettingObj":  {Keys: 1, System: true},
+		"RecallBatchObj":     {Keys: 3, EnabledWith: "RecallObj"},
+		"RecallTraceCodeObj": {Keys: 2, EnabledWith: "RecallObj"},
+		"TraceCodeLinkObj":   {Keys: 4},
+		"TraceCodeChildObj":  {Keys: 4, EnabledWith: "TraceCodeLinkObj"},
+		"TransferObj":        {Keys: 2},
+		"CustodyObj":         {Keys: 1, EnabledWith: "TransferObj"},
+		"InventoryObj": {
+			KeyFields:   []string{"AccountNo", "SkuId", "BatchNum"},
+			EmptyKeys:   []string{"BatchNum"},
+			EnabledWith: "SkuTransactionObj",
+		},
+		"SensorViolationObj": {Keys: 1, EnabledWith: "SensorReadingObj"},
+		"AccountKeyObj":      {Keys: 2, EnabledWith: "AccountInfoObj"},
+		"TxInfoObj":          {Keys: 1, System: true},
+		"ChaincodeConfigObj": {Keys: 1, System: true},
+	}
+	for name, objectType := range ObjectMap {
+		objectType.Name = name
+	}
+	return ObjectMap
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+// Returns the registered ObjectType, nil for an unknown type
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+func GetObjectType(tname string) *ObjectType {
+	return ObjectTypes()[tname]
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+// The names of the registered Object types, in name order
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+func ObjectTypeNames() []string {
+	var names []string
+	for name := range ObjectTypes() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
 
 /////////////////////////////////////////////////////////////////////////////////////////////////////
 func GetNumberOfKeys(tname string) int {
-	ObjectMap := map[string]int{
-		"SkuTraceRecordObj":        4,
-		"SkuAuthenticationTraceRecordObj":        4,
-		"SkuBaseInfoObj":     1,
-		"SkuTransactionObj":     4,
-		"CertificationAccountInfoObj":     1,
-		"AccountInfoObj":     1,
+	objectType := GetObjectType(tname)
+	if objectType == nil {
+		return 0
+	}
+	if len(objectType.KeyFields) > 0 {
+		return len(objectType.KeyFields)
 	}
-	return ObjectMap[tname]
+	return objectType.Keys
 }
 
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+// Attributes that must be present when an Object is posted as a JSON document
+// eg: '{"Function": "iPostSkuBaseInfo", "Args":["{\"SkuId\":\"1\",\"TraceCode\":\"1111\", ...}"]}'
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+func RequiredFields(tname string) []string {
+	if objectType := GetObjectType(tname); objectType != nil {
+		return objectType.Required
+	}
+	return nil
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+// Attributes that make up the key of an Object, in key order
+// Key attributes can not be changed by an update
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+func KeyFields(tname string) []string {
+	if objectType := GetObjectType(tname); objectType != nil {
+		return objectType.KeyFields
+	}
+	return nil
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////
+// Secondary indexes of an Object, by index name the attributes indexed
+// UpdateObject, ReplaceObject and DeleteObject keep an index entry per Object under
+//     ObjectIndex [ObjectType, IndexName, attribute values..., Object keys...]
+// and GetByIndex looks the Objects up by the leading attribute values. An Object is
+// left out of an index while its first indexed attribute is empty. Objects written
+// before an index was declared are indexed when next written
+//////////////////////////////////////////////////////////////////////////////////////////////////
+func ObjectIndexes(tname string) map[string][]string {
+	if objectType := GetObjectType(tname); objectType != nil {
+		return objectType.Indexes
+	}
+	return nil
+}
+
+// Object type the index entries are kept under
+const INDEX_OBJECT_TYPE = "ObjectIndex"
 
 ////////////////////////////////////////////////////////////////////////////
-// Open a Ledgers if one does not exist
-// These ledgers will be used to write /  read data
+// Bring the Objects of objectType under the partial key keys, every one of
+// them when there are no keys, in line with the current schema: the entries
+// of their secondary indexes are written, see UpdateIndexes
 ////////////////////////////////////////////////////////////////////////////
-func InitObject(stub shim.ChaincodeStubInterface, objectType string, keys []string)  error {
+func InitObject(stub shim.ChaincodeStubInterface, objectType string, keys []string) error {
 
-	fmt.Println(">> Not Implemented Yet << Initializing Object : " , objectType, " Keys: ", keys)
+	err := verifyKeys(objectType, keys, 0)
+	if err != nil {
+		fmt.Println("InitObject() Failed: ", err)
+		return err
+	}
+	if len(ObjectIndexes(objectType)) == 0 {
+		return nil
+	}
+
+	rs, err := stub.GetStateByPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return err
+	}
+	defer rs.Close()
+
+	nObjects := 0
+	for rs.HasNext() {
+		compositeKey, value, err := rs.Next()
+		if err != nil {
+			fmt.Println("InitObject() : Failed to iterate ", objectType, " : ", err)
+			return err
+		}
+		_, objectKeys, err := stub.SplitCompositeKey(compositeKey)
+		if err != nil {
+			return err
+		}
+		err = IndexObject(stub, objectType, objectKeys, value)
+		if err != nil {
+			return err
+		}
+		nObjects++
+	}
+
+	fmt.Println("InitObject() : Initialized ", nObjects, " ", objectType, " Keys: ", keys)
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Write the index entries of the Object stored under keys as objectData
+////////////////////////////////////////////////////////////////////////////
+func IndexObject(stub shim.ChaincodeStubInterface, objectType string, keys []string, objectData []byte) error {
+
+	entries, err := IndexEntries(stub, objectType, keys, objectData)
+	if err != nil {
+		return err
+	}
+	indexValue, _ := json.Marshal(keys)
+	for entry := range entries {
+		err = stub.PutState(entry, indexValue)
+		if err != nil {
+			fmt.Println("IndexObject() : Error inserting index entry of ", objectType, " : ", err)
+			return err
+		}
+	}
 	return nil
 }
 
+////////////////////////////////////////////////////////////////////////////
+// Call visit for the Objects of objectTypes, type after type, starting at
+// the composite key bookmark and stopping after batchSize of them. Returns
+// the composite key of the first Object left, empty once all were visited,
+// and the number visited
+////////////////////////////////////////////////////////////////////////////
+func VisitObjects(stub shim.ChaincodeStubInterface, objectTypes []string, bookmark string, batchSize int,
+	visit func(objectType string, compositeKey string, keys []string, objectData []byte) error) (string, int, error) {
+
+	started := bookmark == ""
+	startType := ""
+	if !started {
+		var err error
+		startType, _, err = stub.SplitCompositeKey(bookmark)
+		if err != nil {
+			return "", 0, err
+		}
+	}
+
+	nObjects := 0
+	for _, objectType := range objectTypes {
+		startKey := ""
+		if !started {
+			if objectType != startType {
+				continue
+			}
+			started = true
+			startKey = bookmark
+		}
+		next, visited, err := visitObjects(stub, objectType, startKey, batchSize-nObjects, visit)
+		nObjects += visited
+		if err != nil || next != "" {
+			return next, nObjects, err
+		}
+	}
+	if !started {
+		return "", 0, errors.New("VisitObjects() Failed: bookmark of an Object type not visited : " + startType)
+	}
+	return "", nObjects, nil
+}
+
+func visitObjects(stub shim.ChaincodeStubInterface, objectType string, startKey string, batchSize int,
+	visit func(objectType string, compositeKey string, keys []string, objectData []byte) error) (string, int, error) {
+
+	rs, err := GetListFrom(stub, objectType, nil, startKey)
+	if err != nil {
+		return "", 0, err
+	}
+	defer rs.Close()
+
+	visited := 0
+	for rs.HasNext() {
+		compositeKey, value, err := rs.Next()
+		if err != nil {
+			fmt.Println("VisitObjects() : Failed to iterate ", objectType, " : ", err)
+			return "", visited, err
+		}
+		if visited == batchSize {
+			return compositeKey, visited, nil
+		}
+		_, keys, err := stub.SplitCompositeKey(compositeKey)
+		if err != nil {
+			return "", visited, err
+		}
+		err = visit(objectType, compositeKey, keys, value)
+		if err != nil {
+			return "", visited, err
+		}
+		visited++
+	}
+	return "", visited, nil
+}
+
 ////////////////////////////////////////////////////////////////////////////
 // Update the Object - Replace current data with replacement
 // Register users into this table
@@ -78,7 +451,7 @@ func UpdateObject(stub shim.ChaincodeStubInterface, objectType string, keys []st
 
         // Check how many keys
 
-        err := VerifyAtLeastOneKeyIsPresent(objectType, keys )
+        err := VerifyAllKeysArePresent(objectType, keys )
         if err != nil {
                 return err
         }
@@ -86,10 +459,28 @@ func UpdateObject(stub shim.ChaincodeStubInterface, objectType string, keys []st
 	// Convert keys to  compound key
 	compositeKey, _ := stub.CreateCompositeKey(objectType, keys)
 
+	// Check the Object type is enabled on this channel, see trace_config.go
+	err = CheckObjectTypeEnabled(stub, objectType)
+	if err != nil {
+		return err
+	}
+
+	// Check the submitter may write the record, see trace_policy.go
+	err = AuthorizeObjectWrite(stub, objectType, compositeKey, objectData)
+	if err != nil {
+		return err
+	}
+
+	// Keep the secondary indexes in step
+	err = UpdateIndexes(stub, objectType, keys, compositeKey, objectData)
+	if err != nil {
+		return err
+	}
+
 	// Add Object JSON to state
 	err = stub.PutState(compositeKey, objectData)
 	if err != nil {
-		fmt.Println("UpdateObject() : Error inserting Object into State Database %s", err)
+		fmt.Println("UpdateObject() : Error inserting Object into State Database : ", err)
 		return err
 	}
 
@@ -106,7 +497,7 @@ func ReplaceObject(stub shim.ChaincodeStubInterface, objectType string, keys []s
 
         // Check how many keys
 
-        err := VerifyAtLeastOneKeyIsPresent(objectType, keys )
+        err := VerifyAllKeysArePresent(objectType, keys )
         if err != nil {
                 return err
         }
@@ -114,10 +505,28 @@ func ReplaceObject(stub shim.ChaincodeStubInterface, objectType string, keys []s
 	// Convert keys to  compound key
 	compositeKey, _ := stub.CreateCompositeKey(objectType, keys)
 
+	// Check the Object type is enabled on this channel, see trace_config.go
+	err = CheckObjectTypeEnabled(stub, objectType)
+	if err != nil {
+		return err
+	}
+
+	// Check the submitter may write the record, see trace_policy.go
+	err = AuthorizeObjectWrite(stub, objectType, compositeKey, objectData)
+	if err != nil {
+		return err
+	}
+
+	// Keep the secondary indexes in step
+	err = UpdateIndexes(stub, objectType, keys, compositeKey, objectData)
+	if err != nil {
+		return err
+	}
+
 	// Add Party JSON to state
 	err = stub.PutState(compositeKey, objectData)
 	if err != nil {
-		fmt.Println("ReplaceObject() : Error replacing Object in State Database %s", err)
+		fmt.Println("ReplaceObject() : Error replacing Object in State Database : ", err)
 		return err
 	}
 
@@ -133,7 +542,7 @@ func QueryObject(stub shim.ChaincodeStubInterface, objectType string, keys []str
 
         // Check how many keys
 
-        err := VerifyAtLeastOneKeyIsPresent(objectType, keys )
+        err := VerifyAllKeysArePresent(objectType, keys )
         if err != nil {
                 return nil, err
         }
@@ -146,10 +555,191 @@ func QueryObject(stub shim.ChaincodeStubInterface, objectType string, keys []str
                 return nil, err
         }
 
+	// A deleted Object is not there, see trace_delete.go
+	if IsTombstone(Avalbytes) {
+		return nil, nil
+	}
         return Avalbytes, nil
 }
 
 
+////////////////////////////////////////////////////////////////////////////
+// Move the index entries of the Object under compositeKey to objectData,
+// nil when the Object is deleted. Entries of attribute values that changed
+// are removed
+////////////////////////////////////////////////////////////////////////////
+func UpdateIndexes(stub shim.ChaincodeStubInterface, objectType string, keys []string, compositeKey string, objectData []byte) error {
+
+	if len(ObjectIndexes(objectType)) == 0 {
+		return nil
+	}
+	current, err := stub.GetState(compositeKey)
+	if err != nil {
+		return err
+	}
+	stale, err := IndexEntries(stub, objectType, keys, current)
+	if err != nil {
+		return err
+	}
+	entries, err := IndexEntries(stub, objectType, keys, objectData)
+	if err != nil {
+		return err
+	}
+
+	for entry := range entries {
+		if stale[entry] {
+			delete(stale, entry)
+			continue
+		}
+		value, _ := json.Marshal(keys)
+		err = stub.PutState(entry, value)
+		if err != nil {
+			fmt.Println("UpdateIndexes() : Error inserting index entry of ", objectType, " : ", err)
+			return err
+		}
+	}
+	for entry := range stale {
+		err = stub.DelState(entry)
+		if err != nil {
+			fmt.Println("UpdateIndexes() : Error removing index entry of ", objectType, " : ", err)
+			return err
+		}
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// The index entries of an Object, none for a missing or deleted Object
+////////////////////////////////////////////////////////////////////////////
+func IndexEntries(stub shim.ChaincodeStubInterface, objectType string, keys []string, objectData []byte) (map[string]bool, error) {
+
+	entries := map[string]bool{}
+	if objectData == nil || IsTombstone(objectData) {
+		return entries, nil
+	}
+	var attributes map[string]interface{}
+	err := json.Unmarshal(objectData, &attributes)
+	if err != nil {
+		return nil, err
+	}
+
+	for indexName, fields := range ObjectIndexes(objectType) {
+		parts := []string{objectType, indexName}
+		for _, field := range fields {
+			value, _ := attributes[field].(string)
+			parts = append(parts, value)
+		}
+		if parts[2] == "" {
+			continue
+		}
+		entry, err := stub.CreateCompositeKey(INDEX_OBJECT_TYPE, append(parts, keys...))
+		if err != nil {
+			return nil, err
+		}
+		entries[entry] = true
+	}
+	return entries, nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Retrieve the Objects whose indexed attributes start with values
+// eg: values, err := GetByIndex(stub, "SkuTraceRecordObj", "SkuId", []string{"SkuId", "BatchNum"})
+////////////////////////////////////////////////////////////////////////////
+func GetByIndex(stub shim.ChaincodeStubInterface, objectType string, indexName string, values []string) ([][]byte, error) {
+
+	fields, ok := ObjectIndexes(objectType)[indexName]
+	if !ok {
+		return nil, fmt.Errorf("GetByIndex() Failed: %s has no index %s", objectType, indexName)
+	}
+	if len(values) < 1 || len(values) > len(fields) {
+		return nil, fmt.Errorf("GetByIndex() Failed: index %s of %s takes 1 to %d values %v", indexName, objectType, len(fields), fields)
+	}
+
+	rs, err := stub.GetStateByPartialCompositeKey(INDEX_OBJECT_TYPE, append([]string{objectType, indexName}, values...))
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+
+	var objects [][]byte
+	for rs.HasNext() {
+		_, value, err := rs.Next()
+		if err != nil {
+			fmt.Println("GetByIndex() : Failed to iterate ", objectType, " : ", err)
+			return nil, err
+		}
+		var keys []string
+		err = json.Unmarshal(value, &keys)
+		if err != nil {
+			return nil, err
+		}
+		object, err := QueryObject(stub, objectType, keys)
+		if err != nil {
+			return nil, err
+		}
+		if object == nil {
+			continue
+		}
+
+		// An entry left behind by a change the index missed is not a match
+		var attributes map[string]interface{}
+		err = json.Unmarshal(object, &attributes)
+		if err != nil {
+			return nil, err
+		}
+		matches := true
+		for i, value := range values {
+			current, _ := attributes[fields[i]].(string)
+			matches = matches && current == value
+		}
+		if matches {
+			objects = append(objects, object)
+		}
+	}
+	return objects, nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// A version of an Object, Value is empty when the Object was deleted
+////////////////////////////////////////////////////////////////////////////
+type ObjectModification struct {
+	TxId  string
+	Value []byte
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Retrieve every version of an Object by Object Name and Key, oldest first
+// This has to be a full key
+////////////////////////////////////////////////////////////////////////////
+func QueryObjectHistory(stub shim.ChaincodeStubInterface, objectType string, keys []string) ([]ObjectModification, error) {
+
+	err := VerifyAllKeysArePresent(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	compoundKey, err := stub.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := stub.GetHistoryForKey(compoundKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+
+	var versions []ObjectModification
+	for rs.HasNext() {
+		txId, value, err := rs.Next()
+		if err != nil {
+			fmt.Println("QueryObjectHistory() : Failed to iterate ", objectType, " : ", err)
+			return nil, err
+		}
+		versions = append(versions, ObjectModification{txId, value})
+	}
+	return versions, nil
+}
+
 //////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 // Retrieve a list of Objects from the Query
 // The function returns an iterator from which objects can be retrieved.
@@ -195,24 +785,546 @@ func GetList(stub shim.ChaincodeStubInterface, objectType string, keys []string)
 }
 
 ////////////////////////////////////////////////////////////////////////////
-// This function verifies if the number of key provided is at least 1 and
-// < the the max keys defined for the Object
+// Retrieve all the Objects of a partial key range
+// The values are returned in key order, deleted Objects are left out
 ////////////////////////////////////////////////////////////////////////////
+func GetListValues(stub shim.ChaincodeStubInterface, objectType string, keys []string) ([][]byte, error) {
 
+	values, _, err := GetListValuesWithTombstones(stub, objectType, keys)
+	return values, err
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Retrieve all the Objects of a partial key range, and apart the
+// tombstones of the deleted ones
+////////////////////////////////////////////////////////////////////////////
+func GetListValuesWithTombstones(stub shim.ChaincodeStubInterface, objectType string, keys []string) ([][]byte, [][]byte, error) {
+
+	rs, err := GetList(stub, objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rs.Close()
+
+	var values, tombstones [][]byte
+	for rs.HasNext() {
+		_, value, err := rs.Next()
+		if err != nil {
+			fmt.Println("GetListValues() : Failed to iterate ", objectType, " : ", err)
+			return nil, nil, err
+		}
+		if IsTombstone(value) {
+			tombstones = append(tombstones, value)
+			continue
+		}
+		values = append(values, value)
+	}
+	return values, tombstones, nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Retrieve the Objects matching a CouchDB rich query
+// Only available when the peer state database is CouchDB
+// eg: values, err := GetQueryResultValues(stub, `{"selector":{"StationType":"Factory"}}`)
+////////////////////////////////////////////////////////////////////////////
+func GetQueryResultValues(stub shim.ChaincodeStubInterface, query string) ([][]byte, error) {
+
+	rs, err := stub.GetQueryResult(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rs.Close()
+
+	var values [][]byte
+	for rs.HasNext() {
+		_, value, err := rs.Next()
+		if err != nil {
+			fmt.Println("GetQueryResultValues() : Failed to iterate ", query, " : ", err)
+			return nil, err
+		}
+		if IsTombstone(value) {
+			continue
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Return an iterator over the Objects of a partial key range, starting at
+// the composite key startKey, or at the start of the range if it is empty.
+// The range ends where GetStateByPartialCompositeKey ends it, the Fabric 1.0
+// shim reads that one with GetStateByRange too
+////////////////////////////////////////////////////////////////////////////
+func GetListFrom(stub shim.ChaincodeStubInterface, objectType string, keys []string, startKey string) (shim.StateQueryIteratorInterface, error) {
+
+	partialKey, err := stub.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	if startKey == "" {
+		startKey = partialKey
+	}
+	return stub.GetStateByRange(startKey, partialKey+string(utf8.MaxRune))
+}
+
+// The largest page GetListPage returns
+const MAX_PAGE_SIZE = 1000
+
+////////////////////////////////////////////////////////////////////////////
+// Retrieve one page of Objects of a partial key range
+// The page starts at bookmark, the bookmark returned with the previous page,
+// or at the start of the range if bookmark is empty. The bookmark of the
+// next page is empty once the range is exhausted. Deleted Objects are left out
+// eg: values, bookmark, err := GetListPage(stub, "SkuTraceRecordObj", []string{"1111"}, 100, "")
+////////////////////////////////////////////////////////////////////////////
+func GetListPage(stub shim.ChaincodeStubInterface, objectType string, keys []string, pageSize int, bookmark string) ([][]byte, string, error) {
+
+	values, _, nextBookmark, err := GetListPageWithTombstones(stub, objectType, keys, pageSize, bookmark)
+	return values, nextBookmark, err
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Retrieve one page of Objects of a partial key range, and apart the
+// tombstones of the deleted ones met on the page. Tombstones do not count
+// towards pageSize
+////////////////////////////////////////////////////////////////////////////
+func GetListPageWithTombstones(stub shim.ChaincodeStubInterface, objectType string, keys []string, pageSize int, bookmark string) ([][]byte, [][]byte, string, error) {
+
+	err := VerifyAtLeastOneKeyIsPresent(objectType, keys)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if pageSize < 1 || pageSize > MAX_PAGE_SIZE {
+		return nil, nil, "", fmt.Errorf("GetListPage() Failed: pageSize must be between 1 and %d", MAX_PAGE_SIZE)
+	}
+
+	// The bookmark is the composite key of the first Object of the page
+	partialKey, err := stub.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	startKey := ""
+	if bookmark != "" {
+		decoded, err := base64.StdEncoding.DecodeString(bookmark)
+		if err != nil || !strings.HasPrefix(string(decoded), partialKey) {
+			return nil, nil, "", errors.New("GetListPage() Failed: bookmark does not belong to this list")
+		}
+		startKey = string(decoded)
+	}
+
+	rs, err := GetListFrom(stub, objectType, keys, startKey)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer rs.Close()
+
+	var values, tombstones [][]byte
+	nextBookmark := ""
+	for rs.HasNext() {
+		key, value, err := rs.Next()
+		if err != nil {
+			fmt.Println("GetListPage() : Failed to iterate ", objectType, " : ", err)
+			return nil, nil, "", err
+		}
+		if IsTombstone(value) {
+			tombstones = append(tombstones, value)
+			continue
+		}
+		if len(values) == pageSize {
+			nextBookmark = base64.StdEncoding.EncodeToString([]byte(key))
+			break
+		}
+		values = append(values, value)
+	}
+	return values, tombstones, nextBookmark, nil
+}
+
+var (
+	ErrUnknownObjectType = errors.New("UNKNOWN_OBJECT_TYPE")
+	ErrKeyMissing        = errors.New("KEY_MISSING")
+	ErrKeyEmpty          = errors.New("KEY_EMPTY")
+	ErrTooManyKeys       = errors.New("TOO_MANY_KEYS")
+)
+
+////////////////////////////////////////////////////////////////////////////
+// Error returned when the keys given for an Object do not fit its type
+// Err is one of the Err* values above, Part the 1 based key part at fault
+////////////////////////////////////////////////////////////////////////////
+type KeyError struct {
+	Err        error
+	ObjectType string
+	Part       int
+	Field      string
+	Want       int
+	Got        int
+}
+
+func (e *KeyError) Error() string {
+	part := fmt.Sprintf("key %d", e.Part)
+	if e.Field != "" {
+		part += " (" + e.Field + ")"
+	}
+	switch e.Err {
+	case ErrUnknownObjectType:
+		return fmt.Sprintf("%s : %s", e.Err, e.ObjectType)
+	case ErrKeyMissing:
+		return fmt.Sprintf("%s : %s %s is missing, got %d of %d keys", e.Err, e.ObjectType, part, e.Got, e.Want)
+	case ErrKeyEmpty:
+		return fmt.Sprintf("%s : %s %s is empty", e.Err, e.ObjectType, part)
+	}
+	return fmt.Sprintf("%s : %s has %d keys, got %d", e.Err, e.ObjectType, e.Want, e.Got)
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+////////////////////////////////////////////////////////////////////////////
+// This function verifies the keys are the full key of a registered Object,
+// as needed to write or query one Object
+////////////////////////////////////////////////////////////////////////////
+func VerifyAllKeysArePresent(objectType string, args []string) error {
+
+	err := verifyKeys(objectType, args, GetNumberOfKeys(objectType))
+	if err != nil {
+		fmt.Println("VerifyAllKeysArePresent() Failed: ", err)
+	}
+	return err
+}
+
+////////////////////////////////////////////////////////////////////////////
+// This function verifies if the number of key provided is at least 1 and
+// no more than the keys defined for the Object, as needed to list the
+// Objects of a partial key
+////////////////////////////////////////////////////////////////////////////
 func VerifyAtLeastOneKeyIsPresent(objectType string, args []string) error {
 
-        // Check how many keys
-        nKeys := GetNumberOfKeys(objectType)
-        nCol := len(args)
-	if nCol == 1 {
-		return nil
+	err := verifyKeys(objectType, args, 1)
+	if err != nil {
+		fmt.Println("VerifyAtLeastOneKeyIsPresent() Failed: ", err)
 	}
+	return err
+}
 
-        if nCol < 1 {
-		error_str :=  fmt.Sprintf("VerifyAtLeastOneKeyIsPresent() Failed: Atleast 1 Key must is needed :  nKeys : %s, nCol : %s ", nKeys, nCol)
-                fmt.Println(error_str)
-                return errors.New(error_str)
-        }
+// Check args against the key of objectType, of which the first min parts
+// are needed
+func verifyKeys(objectType string, args []string, min int) error {
+
+	otype := GetObjectType(objectType)
+	if otype == nil {
+		return &KeyError{Err: ErrUnknownObjectType, ObjectType: objectType}
+	}
+	nKeys := GetNumberOfKeys(objectType)
+	field := func(i int) string {
+		if i < len(otype.KeyFields) {
+			return otype.KeyFields[i]
+		}
+		return ""
+	}
 
-        return nil
+	nCol := len(args)
+	if nCol > nKeys {
+		return &KeyError{Err: ErrTooManyKeys, ObjectType: objectType, Want: nKeys, Got: nCol}
+	}
+	if nCol < min {
+		return &KeyError{Err: ErrKeyMissing, ObjectType: objectType, Part: nCol + 1, Field: field(nCol), Want: nKeys, Got: nCol}
+	}
+	for i, key := range args {
+		if key != "" {
+			continue
+		}
+		mayBeEmpty := false
+		for _, name := range otype.EmptyKeys {
+			mayBeEmpty = mayBeEmpty || name == field(i)
+		}
+		if !mayBeEmpty {
+			return &KeyError{Err: ErrKeyEmpty, ObjectType: objectType, Part: i + 1, Field: field(i), Want: nKeys, Got: nCol}
+		}
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Returns true if the arguments carry a single JSON document instead of
+// the positional list of attributes
+////////////////////////////////////////////////////////////////////////////
+func IsJSONObjectArgs(args []string) bool {
+
+	return len(args) == 1 && strings.HasPrefix(strings.TrimSpace(args[0]), "{")
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Decode a JSON document into an Object
+// Attributes that do not belong to the Object are rejected, and so are
+// required attributes that are missing or empty
+////////////////////////////////////////////////////////////////////////////
+func JSONtoObject(objectType string, objectData []byte, object interface{}, required []string) error {
+
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(objectData, &fields)
+	if err != nil {
+		error_str := fmt.Sprintf("JSONtoObject() Failed: %s is not a JSON object : %s", objectType, err)
+		fmt.Println(error_str)
+		return errors.New(error_str)
+	}
+
+	for _, name := range required {
+		value, ok := fields[name]
+		if !ok || string(value) == "null" || string(value) == "\"\"" {
+			error_str := fmt.Sprintf("JSONtoObject() Failed: %s required attribute %s is missing", objectType, name)
+			fmt.Println(error_str)
+			return errors.New(error_str)
+		}
+	}
+
+	err = CheckJSONFields(object, objectData)
+	if err == nil {
+		err = json.Unmarshal(objectData, object)
+	}
+	if err != nil {
+		error_str := fmt.Sprintf("JSONtoObject() Failed: %s : %s", objectType, err)
+		fmt.Println(error_str)
+		return errors.New(error_str)
+	}
+
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Apply a JSON patch to an Object read from the ledger
+// Only the attributes present in the patch are replaced. Key attributes
+// can not be changed and attributes unknown to the Object are rejected
+////////////////////////////////////////////////////////////////////////////
+func PatchObject(objectType string, object interface{}, patch []byte, keyFields []string) error {
+
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(patch, &fields)
+	if err != nil {
+		error_str := fmt.Sprintf("PatchObject() Failed: %s patch is not a JSON object : %s", objectType, err)
+		fmt.Println(error_str)
+		return errors.New(error_str)
+	}
+
+	objectData, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	var current map[string]json.RawMessage
+	err = json.Unmarshal(objectData, &current)
+	if err != nil {
+		return err
+	}
+
+	// The decoder matches attribute names regardless of case, so does the check
+	for field, value := range fields {
+		for _, name := range keyFields {
+			if !strings.EqualFold(field, name) {
+				continue
+			}
+			var newKey, oldKey string
+			err = json.Unmarshal(current[name], &oldKey)
+			if err != nil {
+				return err
+			}
+			err = json.Unmarshal(value, &newKey)
+			if err != nil || newKey != oldKey {
+				error_str := fmt.Sprintf("PatchObject() Failed: %s key attribute %s can not be changed", objectType, name)
+				fmt.Println(error_str)
+				return errors.New(error_str)
+			}
+		}
+	}
+
+	err = CheckJSONFields(object, patch)
+	if err == nil {
+		err = json.Unmarshal(patch, object)
+	}
+	if err != nil {
+		error_str := fmt.Sprintf("PatchObject() Failed: %s : %s", objectType, err)
+		fmt.Println(error_str)
+		return errors.New(error_str)
+	}
+
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Reject a JSON document naming attributes object does not have, in nested
+// objects too. Names match regardless of case, as they do when decoding.
+// json.Decoder.DisallowUnknownFields does the same from Go 1.10 on only
+////////////////////////////////////////////////////////////////////////////
+func CheckJSONFields(object interface{}, objectData []byte) error {
+
+	return checkJSONFields(reflect.TypeOf(object), objectData)
+}
+
+func checkJSONFields(t reflect.Type, data json.RawMessage) error {
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	// A document of the wrong shape is left to the decoder to report
+	switch t.Kind() {
+	case reflect.Struct:
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(data, &fields) != nil {
+			return nil
+		}
+		for name, value := range fields {
+			field, ok := jsonField(t, name)
+			if !ok {
+				return fmt.Errorf("json: unknown field %q", name)
+			}
+			err := checkJSONFields(field.Type, value)
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		var values []json.RawMessage
+		if json.Unmarshal(data, &values) != nil {
+			return nil
+		}
+		for _, value := range values {
+			err := checkJSONFields(t.Elem(), value)
+			if err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		var values map[string]json.RawMessage
+		if json.Unmarshal(data, &values) != nil {
+			return nil
+		}
+		for _, value := range values {
+			err := checkJSONFields(t.Elem(), value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// The field of struct type t a JSON attribute name decodes into
+func jsonField(t reflect.Type, name string) (reflect.StructField, bool) {
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := strings.Split(field.Tag.Get("json"), ",")[0]
+		if tag == "-" {
+			continue
+		}
+		if field.Anonymous && tag == "" && field.Type.Kind() == reflect.Struct {
+			if embedded, ok := jsonField(field.Type, name); ok {
+				return embedded, true
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if tag == "" {
+			tag = field.Name
+		}
+		if strings.EqualFold(tag, name) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Create an Object of a registered type from the arguments of a chaincode
+// function, either a single JSON document or its attributes in order.
+// The attributes set by the chaincode are not taken positionally
+////////////////////////////////////////////////////////////////////////////
+func ObjectFromArgs(objectType *ObjectType, args []string) (interface{}, error) {
+
+	object := objectType.New()
+	if IsJSONObjectArgs(args) {
+		err := JSONtoObject(objectType.Name, []byte(args[0]), object, objectType.Required)
+		return object, err
+	}
+
+	value := reflect.ValueOf(object).Elem()
+	var fields []reflect.Value
+	for i := 0; i < value.NumField(); i++ {
+		if isChaincodeField(objectType, value.Type().Field(i).Name) {
+			continue
+		}
+		if value.Field(i).Kind() != reflect.String {
+			return object, fmt.Errorf("ObjectFromArgs() : %s can only be posted as a JSON document", objectType.Name)
+		}
+		fields = append(fields, value.Field(i))
+	}
+	if len(args) != len(fields) {
+		error_str := fmt.Sprintf("ObjectFromArgs() : Incorrect number of arguments for %s. Expecting %d ", objectType.Name, len(fields))
+		fmt.Println(error_str)
+		return object, errors.New(error_str)
+	}
+	for i, field := range fields {
+		field.SetString(args[i])
+	}
+	fmt.Println("ObjectFromArgs() : ", objectType.Name, " Object : ", object)
+	return object, nil
+}
+
+func isChaincodeField(objectType *ObjectType, name string) bool {
+	for _, field := range objectType.ChaincodeFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+//////////////////////////////////////////////////////////
+// The key of an Object, from its KeyFields
+//////////////////////////////////////////////////////////
+func ObjectKeys(objectType *ObjectType, object interface{}) []string {
+
+	value := reflect.ValueOf(object).Elem()
+	keys := make([]string, len(objectType.KeyFields))
+	for i, name := range objectType.KeyFields {
+		keys[i] = value.FieldByName(name).String()
+	}
+	return keys
+}
+
+//////////////////////////////////////////////////////////
+// Set the ChaincodeFields of object to those of previous,
+// to their zero value if previous is nil
+//////////////////////////////////////////////////////////
+func KeepChaincodeFields(objectType *ObjectType, previous interface{}, object interface{}) {
+
+	value := reflect.ValueOf(object).Elem()
+	for _, name := range objectType.ChaincodeFields {
+		field := value.FieldByName(name)
+		if previous == nil {
+			field.Set(reflect.Zero(field.Type()))
+			continue
+		}
+		field.Set(reflect.ValueOf(previous).Elem().FieldByName(name))
+	}
+}
+
+//////////////////////////////////////////////////////////
+// Read the Object of a registered type stored under keys,
+// nil if there is none
+//////////////////////////////////////////////////////////
+func LoadObject(stub shim.ChaincodeStubInterface, objectType *ObjectType, keys []string) (interface{}, error) {
+
+	Avalbytes, err := QueryObject(stub, objectType.Name, keys)
+	if err != nil || Avalbytes == nil {
+		return nil, err
+	}
+	object := objectType.New()
+	err = json.Unmarshal(Avalbytes, object)
+	if err != nil {
+		fmt.Println("LoadObject() : ", objectType.Name, " Unmarshal error : ", err)
+		return nil, err
+	}
+	return object, nil
 }
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+///////////////////////////////////////////////////////////////////////////////////////
+//
+// Aggregation of TraceCodes
+//
+// Units are packed into cases and cases onto pallets. Packing a child TraceCode into
+// a parent TraceCode records a link, open from AggregatedAt until DisaggregatedAt.
+// A child is in at most one parent at a time, and a TraceCode can not end up inside
+// itself.
+//
+// A link is kept under both ends
+//     TraceCodeLinkObj  : Child, Parent, AggregatedAt, TxId
+//     TraceCodeChildObj : Parent, Child, AggregatedAt, TxId
+//
+// The journey of a child inherits the SkuTraceRecordObj posted for its ancestors
+// while it was inside them, so one record posted for a pallet moves everything on it
+///////////////////////////////////////////////////////////////////////////////////////
+
+// Ancestor levels followed before giving up, well above pallet, case, unit
+const MAX_AGGREGATION_DEPTH = 32
+
+type TraceCodeLinkObj struct {
+	Parent          string
+	Child           string
+	AggregatedAt    string // Transaction time of iAggregate
+	DisaggregatedAt string // Transaction time of iDisaggregate, empty while the child is inside the parent
+	TxId            string // Transaction of iAggregate, AggregatedAt is to the second
+}
+
+func (link TraceCodeLinkObj) linkKeys() []string {
+	return []string{link.Child, link.Parent, link.AggregatedAt, link.TxId}
+}
+
+func (link TraceCodeLinkObj) childKeys() []string {
+	return []string{link.Parent, link.Child, link.AggregatedAt, link.TxId}
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Pack child TraceCodes into a parent TraceCode
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iAggregate", "Args":["Parent", "Child1", "Child2"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func Aggregate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) < 2 {
+		return shim.Error("Aggregate(): Incorrect number of arguments. Expecting Parent and at least one Child")
+	}
+	parent := args[0]
+	now, err := GetTxTime(stub)
+	if err != nil {
+		return shim.Error("Aggregate(): " + err.Error())
+	}
+
+	ancestors, err := GetAncestorLinks(stub, parent)
+	if err != nil {
+		return shim.Error("Aggregate(): " + err.Error())
+	}
+
+	seen := map[string]bool{}
+	var events []ObjectEvent
+	for _, child := range args[1:] {
+		if child == "" || seen[child] {
+			return shim.Error("Aggregate(): Child TraceCodes must be present and distinct : " + child)
+		}
+		seen[child] = true
+
+		if child == parent {
+			return shim.Error("Aggregate(): " + child + " can not be packed into itself")
+		}
+		for _, link := range ancestors {
+			if link.Parent == child {
+				return shim.Error("Aggregate(): " + child + " already contains " + parent)
+			}
+		}
+		current, err := GetParentLink(stub, child)
+		if err != nil {
+			return shim.Error("Aggregate(): " + err.Error())
+		}
+		if current != nil {
+			return Conflict("Aggregate(): " + child + " is already inside " + current.Parent)
+		}
+
+		link := TraceCodeLinkObj{Parent: parent, Child: child, AggregatedAt: now.Format(TimeLayout), TxId: stub.GetTxID()}
+		buff, err := PutTraceCodeLink(stub, link, UpdateObject)
+		if err != nil {
+			return shim.Error("Aggregate(): " + err.Error())
+		}
+		events = append(events, ObjectEvent{"TraceCodeLinkObj", link.linkKeys(), buff})
+	}
+
+	err = EmitObjectBatchEvent(stub, EVENT_AGGREGATED, "TraceCodeLinkObj", events)
+	if err != nil {
+		return shim.Error("Aggregate(): " + err.Error())
+	}
+	return shim.Success([]byte("OK"))
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Unpack child TraceCodes from a parent TraceCode, all of them if none are listed
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iDisaggregate", "Args":["Parent", "Child1"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func Disaggregate(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) < 1 {
+		return shim.Error("Disaggregate(): Incorrect number of arguments. Expecting Parent [, Child ...]")
+	}
+	parent := args[0]
+	now, err := GetTxTime(stub)
+	if err != nil {
+		return shim.Error("Disaggregate(): " + err.Error())
+	}
+
+	children, err := GetChildLinks(stub, parent)
+	if err != nil {
+		return shim.Error("Disaggregate(): " + err.Error())
+	}
+	var links []TraceCodeLinkObj
+	if len(args) == 1 {
+		links = children
+	} else {
+		for _, child := range args[1:] {
+			found := false
+			for _, link := range children {
+				if link.Child == child {
+					links = append(links, link)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return NotFound("Disaggregate(): " + child + " is not inside " + parent)
+			}
+		}
+	}
+
+	var events []ObjectEvent
+	for _, link := range links {
+		link.DisaggregatedAt = now.Format(TimeLayout)
+		buff, err := PutTraceCodeLink(stub, link, ReplaceObject)
+		if err != nil {
+			return shim.Error("Disaggregate(): " + err.Error())
+		}
+		events = append(events, ObjectEvent{"TraceCodeLinkObj", link.linkKeys(), buff})
+	}
+
+	err = EmitObjectBatchEvent(stub, EVENT_DISAGGREGATED, "TraceCodeLinkObj", events)
+	if err != nil {
+		return shim.Error("Disaggregate(): " + err.Error())
+	}
+	return shim.Success([]byte("OK"))
+}
+
+//////////////////////////////////////////////////////////
+// Write a link under both of its ends
+//////////////////////////////////////////////////////////
+func PutTraceCodeLink(stub shim.ChaincodeStubInterface, link TraceCodeLinkObj,
+	write func(stub shim.ChaincodeStubInterface, objectType string, keys []string, objectData []byte) error) ([]byte, error) {
+
+	buff, err := json.Marshal(link)
+	if err != nil {
+		return nil, err
+	}
+	err = write(stub, "TraceCodeLinkObj", link.linkKeys(), buff)
+	if err != nil {
+		return nil, err
+	}
+	err = write(stub, "TraceCodeChildObj", link.childKeys(), buff)
+	if err != nil {
+		return nil, err
+	}
+	return buff, nil
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+// Get the TraceCodes a TraceCode is inside, from its parent up
+// peer chaincode query -l golang -n test_trace -c '{"Function": "qGetAncestors", "Args": ["1111"]}' -o orderer0:7050
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+func GetAncestors(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	links, err := GetAncestorLinks(stub, args[0])
+	if err != nil {
+		return shim.Error("GetAncestors() operation failed. " + err.Error())
+	}
+	buff, err := json.Marshal(links)
+	if err != nil {
+		return shim.Error("GetAncestors() operation failed - Marshall Error. " + err.Error())
+	}
+	return shim.Success(buff)
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+// Get the TraceCodes inside a TraceCode, level by level
+// peer chaincode query -l golang -n test_trace -c '{"Function": "qGetDescendants", "Args": ["1111"]}' -o orderer0:7050
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+func GetDescendants(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var links []TraceCodeLinkObj
+	level := []string{args[0]}
+	seen := map[string]bool{args[0]: true}
+	for depth := 0; len(level) > 0 && depth < MAX_AGGREGATION_DEPTH; depth++ {
+		var next []string
+		for _, parent := range level {
+			children, err := GetChildLinks(stub, parent)
+			if err != nil {
+				return shim.Error("GetDescendants() operation failed. " + err.Error())
+			}
+			for _, link := range children {
+				if seen[link.Child] {
+					continue
+				}
+				seen[link.Child] = true
+				links = append(links, link)
+				next = append(next, link.Child)
+			}
+		}
+		level = next
+	}
+
+	buff, err := json.Marshal(links)
+	if err != nil {
+		return shim.Error("GetDescendants() operation failed - Marshall Error. " + err.Error())
+	}
+	return shim.Success(buff)
+}
+
+//////////////////////////////////////////////////////////
+// The open links above traceCode, from its parent up
+//////////////////////////////////////////////////////////
+func GetAncestorLinks(stub shim.ChaincodeStubInterface, traceCode string) ([]TraceCodeLinkObj, error) {
+
+	var links []TraceCodeLinkObj
+	for depth := 0; depth < MAX_AGGREGATION_DEPTH; depth++ {
+		link, err := GetParentLink(stub, traceCode)
+		if err != nil || link == nil {
+			return links, err
+		}
+		links = append(links, *link)
+		traceCode = link.Parent
+	}
+	return nil, errors.New("GetAncestorLinks() : more than MAX_AGGREGATION_DEPTH levels above " + traceCode)
+}
+
+//////////////////////////////////////////////////////////
+// The open link of traceCode to its parent, nil if it is
+// not inside anything
+//////////////////////////////////////////////////////////
+func GetParentLink(stub shim.ChaincodeStubInterface, traceCode string) (*TraceCodeLinkObj, error) {
+
+	links, err := LoadTraceCodeLinks(stub, "TraceCodeLinkObj", traceCode)
+	if err != nil {
+		return nil, err
+	}
+	for i := range links {
+		if links[i].DisaggregatedAt == "" {
+			return &links[i], nil
+		}
+	}
+	return nil, nil
+}
+
+//////////////////////////////////////////////////////////
+// The open links of traceCode to its children
+//////////////////////////////////////////////////////////
+func GetChildLinks(stub shim.ChaincodeStubInterface, traceCode string) ([]TraceCodeLinkObj, error) {
+
+	links, err := LoadTraceCodeLinks(stub, "TraceCodeChildObj", traceCode)
+	if err != nil {
+		return nil, err
+	}
+	var open []TraceCodeLinkObj
+	for _, link := range links {
+		if link.DisaggregatedAt == "" {
+			open = append(open, link)
+		}
+	}
+	return open, nil
+}
+
+//////////////////////////////////////////////////////////
+// All the links, open or not, kept under traceCode
+//////////////////////////////////////////////////////////
+func LoadTraceCodeLinks(stub shim.ChaincodeStubInterface, objectType string, traceCode string) ([]TraceCodeLinkObj, error) {
+
+	values, err := GetListValues(stub, objectType, []string{traceCode})
+	if err != nil {
+		return nil, err
+	}
+	var links []TraceCodeLinkObj
+	for _, value := range values {
+		var link TraceCodeLinkObj
+		err = json.Unmarshal(value, &link)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// The SkuTraceRecordObj a TraceCode inherits from the TraceCodes it was
+// inside, each one posted while it was inside, and the links followed
+////////////////////////////////////////////////////////////////////////////
+func LoadInheritedSkuTraceRecords(stub shim.ChaincodeStubInterface, traceCode string) ([]SkuTraceRecordObj, []TraceCodeLinkObj, error) {
+
+	var records []SkuTraceRecordObj
+	var followed []TraceCodeLinkObj
+
+	var walk func(code string, from string, to string, depth int) error
+	walk = func(code string, from string, to string, depth int) error {
+		if depth >= MAX_AGGREGATION_DEPTH {
+			return errors.New("LoadInheritedSkuTraceRecords() : more than MAX_AGGREGATION_DEPTH levels above " + traceCode)
+		}
+		links, err := LoadTraceCodeLinks(stub, "TraceCodeLinkObj", code)
+		if err != nil {
+			return err
+		}
+		for _, link := range links {
+			// The part of the link that falls inside the window of the levels below
+			linkFrom, linkTo := link.AggregatedAt, link.DisaggregatedAt
+			if linkFrom < from {
+				linkFrom = from
+			}
+			if linkTo == "" || (to != "" && to < linkTo) {
+				linkTo = to
+			}
+			if linkTo != "" && linkTo <= linkFrom {
+				continue
+			}
+			followed = append(followed, link)
+
+			parentRecords, err := LoadSkuTraceRecords(stub, link.Parent)
+			if err != nil {
+				return err
+			}
+			for _, rec := range parentRecords {
+				if rec.BeginTime >= linkFrom && (linkTo == "" || rec.BeginTime < linkTo) {
+					records = append(records, rec)
+				}
+			}
+			err = walk(link.Parent, linkFrom, linkTo, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err := walk(traceCode, "", "", 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	fmt.Println("LoadInheritedSkuTraceRecords() : ", traceCode, " inherits ", len(records), " records")
+	return records, followed, nil
+}
+
+//////////////////////////////////////////////////////////
+// Migration to schema version 3: add the TxId to the key
+// of the links written before it was part of it, the one
+// of the migration for want of the original
+//////////////////////////////////////////////////////////
+func RekeyTraceCodeLinks(stub shim.ChaincodeStubInterface, bookmark string, batchSize int) (string, int, error) {
+
+	nMoved := 0
+	next, visited, err := VisitObjects(stub, []string{"TraceCodeLinkObj", "TraceCodeChildObj"}, bookmark, batchSize, func(objectType string, compositeKey string, keys []string, objectData []byte) error {
+
+		if len(keys) != 3 {
+			return nil
+		}
+		var link TraceCodeLinkObj
+		err := json.Unmarshal(objectData, &link)
+		if err != nil {
+			return err
+		}
+		link.TxId = stub.GetTxID()
+		keys = link.linkKeys()
+		if objectType == "TraceCodeChildObj" {
+			keys = link.childKeys()
+		}
+		newKey, _ := stub.CreateCompositeKey(objectType, keys)
+		buff, err := json.Marshal(link)
+		if err != nil {
+			return err
+		}
+		err = stub.PutState(newKey, buff)
+		if err != nil {
+			return err
+		}
+		err = stub.DelState(compositeKey)
+		if err != nil {
+			return err
+		}
+		nMoved++
+		return nil
+	})
+	fmt.Println("RekeyTraceCodeLinks() : Moved ", nMoved, " links")
+	return next, visited, err
+}
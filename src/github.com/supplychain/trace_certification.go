@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////////////
+//
+// Certification body lifecycle
+//
+// A CertificationAccountInfoObj can be revoked or suspended from an EffectiveTime,
+// which may lie in the past. Each change is kept in its StatusChanges
+//     REVOKED   : for good, from EffectiveTime on
+//     SUSPENDED : from EffectiveTime until Until, or for good when Until is empty
+// An authentication whose TimeStamp falls under a change is reported as REVOKED or
+// SUSPENDED, and the body can not post new authentications while it is revoked or
+// suspended.
+///////////////////////////////////////////////////////////////////////////////////////
+
+const (
+	CERTIFICATION_REVOKED   = "REVOKED"
+	CERTIFICATION_SUSPENDED = "SUSPENDED"
+)
+
+var (
+	ErrCertificationBodyRevoked   = errors.New("CERTIFICATION_BODY_REVOKED")
+	ErrCertificationBodySuspended = errors.New("CERTIFICATION_BODY_SUSPENDED")
+)
+
+type CertificationStatusChange struct {
+	Status        string // REVOKED or SUSPENDED
+	EffectiveTime string
+	Until         string `json:",omitempty"` // End of a suspension, empty when open ended
+	Reason        string
+	TimeStamp     string // Transaction time the change was recorded
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Revoke a certification body from EffectiveTime on
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iRevokeCertificationAccount", "Args":["Name",
+// "EffectiveTime", "Reason"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func RevokeCertificationAccount(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 3 {
+		return shim.Error("RevokeCertificationAccount(): Incorrect number of arguments. Expecting Name, EffectiveTime and Reason")
+	}
+	return ChangeCertificationAccountStatus(stub, "RevokeCertificationAccount", EVENT_CERTIFICATION_ACCOUNT_REVOKED,
+		args[0], CertificationStatusChange{Status: CERTIFICATION_REVOKED, EffectiveTime: args[1], Reason: args[2]})
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Suspend a certification body from EffectiveTime on, until Until when given
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iSuspendCertificationAccount", "Args":["Name",
+// "EffectiveTime", "Reason", "Until"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func SuspendCertificationAccount(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 3 && len(args) != 4 {
+		return shim.Error("SuspendCertificationAccount(): Incorrect number of arguments. Expecting Name, EffectiveTime, Reason and optional Until")
+	}
+	change := CertificationStatusChange{Status: CERTIFICATION_SUSPENDED, EffectiveTime: args[1], Reason: args[2]}
+	if len(args) == 4 {
+		change.Until = args[3]
+	}
+	return ChangeCertificationAccountStatus(stub, "SuspendCertificationAccount", EVENT_CERTIFICATION_ACCOUNT_SUSPENDED, args[0], change)
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Record change on the CertificationAccountInfoObj name
+////////////////////////////////////////////////////////////////////////////
+func ChangeCertificationAccountStatus(stub shim.ChaincodeStubInterface, caller string, event string, name string, change CertificationStatusChange) pb.Response {
+
+	effective, err := time.Parse(TimeLayout, change.EffectiveTime)
+	if err != nil {
+		return shim.Error(caller + "(): EffectiveTime is not a valid time : " + change.EffectiveTime)
+	}
+	if change.Until != "" {
+		until, err := time.Parse(TimeLayout, change.Until)
+		if err != nil {
+			return shim.Error(caller + "(): Until is not a valid time : " + change.Until)
+		}
+		if !until.After(effective) {
+			return shim.Error(caller + "(): Until must be after EffectiveTime")
+		}
+	}
+	if change.Reason == "" {
+		return shim.Error(caller + "(): Reason can not be empty")
+	}
+
+	keys := []string{name}
+	Avalbytes, err := QueryObject(stub, "CertificationAccountInfoObj", keys)
+	if err != nil {
+		return shim.Error(caller + "(): " + err.Error())
+	}
+	if Avalbytes == nil {
+		return NotFound(caller + "(): CertificationAccountInfoObj " + name)
+	}
+	acc, err := JSONtoCertificationAccountInfoObj(Avalbytes)
+	if err != nil {
+		return shim.Error(caller + "(): Object UnMarshalling Failed ")
+	}
+	for _, previous := range acc.StatusChanges {
+		if previous.Status == CERTIFICATION_REVOKED {
+			return Conflict(caller + "(): CertificationAccountInfoObj " + name + " is already REVOKED from " + previous.EffectiveTime)
+		}
+	}
+
+	now, err := GetTxTime(stub)
+	if err != nil {
+		return shim.Error(caller + "(): " + err.Error())
+	}
+	change.TimeStamp = now.Format(TimeLayout)
+	acc.StatusChanges = append(acc.StatusChanges, change)
+
+	response := ReplaceCertificationAccountInfoObj(stub, "CertificationAccountInfoObj", acc)
+	if response.Status != shim.OK {
+		fmt.Println(caller + "(): ReplaceCertificationAccountInfoObj() Failed ")
+		return shim.Error(caller + "(): " + response.Message)
+	}
+	err = EmitObjectEvent(stub, event, "CertificationAccountInfoObj", keys, response.Payload)
+	if err != nil {
+		return shim.Error(caller + "(): " + err.Error())
+	}
+	return response
+}
+
+//////////////////////////////////////////////////////////
+// The change acc is under at timeStamp, nil while the
+// body is in good standing. A revocation wins over a
+// suspension
+//////////////////////////////////////////////////////////
+func CertificationStatusAt(acc CertificationAccountInfoObj, timeStamp string) *CertificationStatusChange {
+
+	var found *CertificationStatusChange
+	for i := range acc.StatusChanges {
+		change := &acc.StatusChanges[i]
+		if timeStamp < change.EffectiveTime {
+			continue
+		}
+		if change.Status == CERTIFICATION_REVOKED {
+			return change
+		}
+		if found == nil && (change.Until == "" || timeStamp < change.Until) {
+			found = change
+		}
+	}
+	return found
+}
+
+//////////////////////////////////////////////////////////
+// Error for a body under change
+//////////////////////////////////////////////////////////
+func CertificationStatusError(name string, change *CertificationStatusChange) error {
+
+	err := ErrCertificationBodySuspended
+	if change.Status == CERTIFICATION_REVOKED {
+		err = ErrCertificationBodyRevoked
+	}
+	return &SignatureError{Err: err, Signer: name, Detail: "from " + change.EffectiveTime + " : " + change.Reason}
+}
+
+//////////////////////////////////////////////////////////
+// Certification bodies read while reporting the status
+// of a list of authentications, each read once
+//////////////////////////////////////////////////////////
+type CertificationBodies struct {
+	stub     shim.ChaincodeStubInterface
+	accounts map[string]*CertificationAccountInfoObj
+}
+
+func NewCertificationBodies(stub shim.ChaincodeStubInterface) *CertificationBodies {
+	return &CertificationBodies{stub, map[string]*CertificationAccountInfoObj{}}
+}
+
+//////////////////////////////////////////////////////////
+// Returns the CertificationAccountInfoObj name, nil when
+// it is not registered
+//////////////////////////////////////////////////////////
+func (b *CertificationBodies) Get(name string) (*CertificationAccountInfoObj, error) {
+
+	if acc, ok := b.accounts[name]; ok {
+		return acc, nil
+	}
+	Avalbytes, err := QueryObject(b.stub, "CertificationAccountInfoObj", []string{name})
+	if err != nil {
+		return nil, err
+	}
+	var acc *CertificationAccountInfoObj
+	if Avalbytes != nil {
+		obj, err := JSONtoCertificationAccountInfoObj(Avalbytes)
+		if err != nil {
+			return nil, err
+		}
+		acc = &obj
+	}
+	b.accounts[name] = acc
+	return acc, nil
+}
+
+//////////////////////////////////////////////////////////
+// Status of an authentication at time now, REVOKED or
+// SUSPENDED when its body was at the record TimeStamp
+//////////////////////////////////////////////////////////
+func (b *CertificationBodies) SkuAuthenticationStatus(record SkuAuthenticationTraceRecordObj, now time.Time) (string, error) {
+
+	acc, err := b.Get(record.CertificationBodyName)
+	if err != nil {
+		return "", err
+	}
+	if acc != nil {
+		if change := CertificationStatusAt(*acc, record.TimeStamp); change != nil {
+			return change.Status, nil
+		}
+	}
+	return SkuAuthenticationStatus(record, now), nil
+}
+
+//////////////////////////////////////////////////////////
+// Views of records with their status at time now
+//////////////////////////////////////////////////////////
+func (b *CertificationBodies) SkuAuthenticationViews(records []SkuAuthenticationTraceRecordObj, now time.Time) ([]SkuAuthenticationTraceRecordView, error) {
+
+	var views []SkuAuthenticationTraceRecordView
+	for _, record := range records {
+		status, err := b.SkuAuthenticationStatus(record, now)
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, SkuAuthenticationTraceRecordView{record, status})
+	}
+	return views, nil
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"runtime"
+	"strconv"
+	"strings"
 	"time"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
@@ -25,7 +27,7 @@ import (
 //              "SkuTraceRecordObj":                      4, Key: TraceCode,SkuId,AddressHash,StationType
 //              "SkuAuthenticationTraceRecordObj":        4, Key: TraceCode,SkuId,AddressHash,CertificationBodyType
 //              "SkuBaseInfoObj":                         1, Key: TraceCode
-//              "SkuTransactionObj":                      4, Key: TraceCode, OrderId, SkuId, TransType
+//              "SkuTransactionObj":                      4, Key: TraceCode, SkuId, OrderId, TransType
 //              "CertificationAccountInfoObj":            1, Key: Name
 //              "AccountInfoObj":                         1, Key: Name
 //
@@ -40,13 +42,14 @@ type SkuTraceRecordObj struct {
 	BatchNum       string //
 	StationName    string
 	ExpressNum     string //
-	Signature      string // This is validated for a user registered record
+	Signature      string // This is validated against the AccountInfoObj registered for AddressHash
 	PreStation     string //
 	NextStation    string
 	ExtJsonData    string //
 	BeginTime      string
 	EndTime        string
 	TimeStamp      string // This is the time stamp
+	Discontinuity  bool   `json:",omitempty"` // Set when PreStation does not follow the record before, see trace_continuity.go
 }
 //SKU认证信息
 type SkuAuthenticationTraceRecordObj struct {
@@ -56,7 +59,7 @@ type SkuAuthenticationTraceRecordObj struct {
 	CertificationBodyType    string
 	BatchNum       string //
 	CertificationBodyName    string
-	Signature      string // This is certification body signature for a registered sku
+	Signature      string // This is certification body signature for a registered sku, validated against CertificationAccountInfoObj
 	ExtJsonData    string //
 	BeginTime      string
 	EndTime        string
@@ -89,65 +92,142 @@ type CertificationAccountInfoObj struct {
 	PublicKey      string
 	OrgName        string
 	TimeStamp      string // This is the time stamp
+	StatusChanges  []CertificationStatusChange `json:",omitempty"` // Revocations and suspensions, set by the chaincode
 }
 //SKU交易信息
 type SkuTransactionObj struct {
 	OrderId        string
 	SkuId          string
 	TraceCode      string
-	TransType      string // Sale, Buy, Commission
+	TransType      string // Sale, Buy, Commission, or Transfer written by iAcceptTransfer
 	BatchNum       string // This is generated by the AES Algorithms
 	AccountNo      string
 	Num            string //
 	ExtJsonData    string //
 	Signature      string // This is validated for a user registered record
 	TransDate      string // This is the time stamp
+	Revision       string // Incremented on every write, an update must carry the current one
 }
 
-
 //////////////////////////////////////////////////////////////
 // Invoke Functions based on Function name
 // The function name gets resolved to one of the following calls
-// during an invoke
+// during an invoke, or to a function generated from the Object
+// types, see trace_registry.go
 // peer chaincode  instantiate -v 1.0 -n test_trace -p github.com/hyperledger/fabric/examples/chaincode/go/trace_chaincode -c '{"Args":["init","a","100","b","200"]}' -o orderer0:7050
 //////////////////////////////////////////////////////////////
 func InvokeFunction(fname string) func(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	InvokeFunc := map[string]func(stub shim.ChaincodeStubInterface, args []string) pb.Response{
-		"iPostAccountInfo":                     PostAccountInfo,
-		"iPostSkuTransaction":                  PostSkuTransaction,
 		"iPostSkuTransactionArrary":           PostSkuTransactionArrary,
-		"iPostCertificationAccountInfo":        PostCertificationAccountInfo,
-		"iPostSkuAuthenticationTraceRecord":    PostSkuAuthenticationTraceRecord,
-		"iPostSkuTraceRecord":                  PostSkuTraceRecord,
 		"iPostSkuTraceRecordArrary":           PostSkuTraceRecordArray,
-		"iPostSkuBaseInfo":                     PostSkuBaseInfo,
-		"iPostTransactionId":                   PostTransactionId,
-		"iUpdateAccountInfo":                   UpdateAccountInfo,
 		"iUpdateSkuTransaction":                UpdateSkuTransaction,
-		"iUpdateCertificationAccountInfo":      UpdateCertificationAccountInfo,
-		"iUpdateSkuAuthenticationTraceRecord":  UpdateSkuAuthenticationTraceRecord,
-		"iUpdateSkuTraceRecord":                UpdateSkuTraceRecord,
-		"iUpdateSkuBaseInfo":                   UpdateSkuBaseInfo,
+		"iSetAccessPolicy":                     SetAccessPolicy,
+		"iSetChannelSetting":                   SetChannelSetting,
+		"iSetChaincodeConfig":                  SetChaincodeConfig,
+		"iMigrateSchema":                       MigrateSchemaRecords,
+		"iPostRecall":                          PostRecall,
+		"iCloseRecall":                         CloseRecall,
+		"iRevokeCertificationAccount":          RevokeCertificationAccount,
+		"iSuspendCertificationAccount":         SuspendCertificationAccount,
+		"iAggregate":                           Aggregate,
+		"iDisaggregate":                        Disaggregate,
+		"iProposeTransfer":                     ProposeTransfer,
+		"iAcceptTransfer":                      AcceptTransfer,
+		"iRejectTransfer":                      RejectTransfer,
+		"iPostSensorReadings":                  PostSensorReadings,
+		"iSetSensorThreshold":                  SetSensorThreshold,
+		"iRotateAccountKey":                    RotateAccountKey,
+		"iDeleteSkuTraceRecord":                DeleteSkuTraceRecord,
+		"iDeleteSkuAuthenticationTraceRecord":  DeleteSkuAuthenticationTraceRecord,
+		"iDeleteSkuBaseInfo":                   DeleteSkuBaseInfo,
+		"iDeleteSkuTransaction":                DeleteSkuTransaction,
+		"iDeleteAccountInfo":                   DeleteAccountInfo,
+		"iDeleteCertificationAccountInfo":      DeleteCertificationAccountInfo,
+		"iDeleteRecall":                        DeleteRecall,
+		"iDeleteTraceCodeLink":                 DeleteTraceCodeLink,
+		"iDeleteTransfer":                      DeleteTransfer,
+		"iDeleteSensorReading":                 DeleteSensorReading,
+		"iDeleteSensorThreshold":               DeleteSensorThreshold,
+	}
+	if InvokeFunc[fname] == nil {
+		return ObjectFunctionHandler(fname)
 	}
 	return InvokeFunc[fname]
 }
 
 //////////////////////////////////////////////////////////////
 // Query Functions based on Function name
-//
+// or generated from the Object types, see trace_registry.go
 //////////////////////////////////////////////////////////////
 func QueryFunction(fname string) func(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	QueryFunc := map[string]func(stub shim.ChaincodeStubInterface, args []string) pb.Response{
-		"qGetAccountInfoByAddressHash":                        	GetAccountInfoByAddressHash,
-		"qGetSkuBaseInfoByTraceCode":                           GetSkuBaseInfoByTraceCode,
-		"qGetCertificationAccountInfoByAddressHash":     	GetCertificationAccountInfoByAddressHash,
-		"qGetSkuAuthenticationRecordListByTraceCode":           GetSkuAuthenticationRecordListByTraceCode,
-		"qGetSkuTraceRecordListByTraceCode":                    GetSkuTraceRecordListByTraceCode,
-		"qGetSkuTransactionListByTraceCode":                    GetSkuTransactionListByTraceCode,
+		"qGetAccessPolicy":                                     GetAccessPolicyInfo,
+		"qQuerySkuTraceRecords":                                QuerySkuTraceRecords,
+		"qGetSkuJourney":                                       GetSkuJourney,
+		"qGetChannelSetting":                                   GetChannelSettingInfo,
+		"qGetChaincodeConfig":                                  GetChaincodeConfigInfo,
+		"qGetRecallStatusByTraceCode":                          GetRecallStatusByTraceCode,
+		"qGetAncestors":                                        GetAncestors,
+		"qGetDescendants":                                      GetDescendants,
+		"qGetCustodyByTraceCode":                               GetCustodyByTraceCode,
+		"qGetTransferListByTraceCode":                          GetTransferListByTraceCode,
+		"qGetInventory":                                        GetInventory,
+		"qGetSensorThreshold":                                  GetSensorThresholdInfo,
+		"qGetSensorReadingsByTraceCode":                        GetSensorReadingsByTraceCode,
+		"qGetAccountKeyHistory":                                GetAccountKeyHistory,
+		"qGetObjectHistory":                                    GetObjectHistory,
+		"qGetByIndex":                                          GetByIndexInfo,
+	}
+	if QueryFunc[fname] == nil {
+		return ObjectFunctionHandler(fname)
 	}
 	return QueryFunc[fname]
 }
 
+//////////////////////////////////////////////////////////////
+// Status returned when the Object to be updated is not
+// on the ledger
+//////////////////////////////////////////////////////////////
+const NOT_FOUND = 404
+
+func NotFound(msg string) pb.Response {
+	fmt.Println("NOT_FOUND : ", msg)
+	return pb.Response{Status: NOT_FOUND, Message: "NOT_FOUND : " + msg}
+}
+
+//////////////////////////////////////////////////////////////
+// Status returned when an update was prepared against an
+// older revision of the Object than the one on the ledger
+//////////////////////////////////////////////////////////////
+const CONFLICT = 409
+
+func Conflict(msg string) pb.Response {
+	fmt.Println("CONFLICT : ", msg)
+	return pb.Response{Status: CONFLICT, Message: "CONFLICT : " + msg}
+}
+
+// Returned by a BeforePost hook when the Object is already on the
+// ledger and may only be updated, answered with CONFLICT
+var ErrObjectExists = errors.New("OBJECT_EXISTS")
+
+//////////////////////////////////////////////////////////////
+// Layout of the time attributes of the Objects
+//////////////////////////////////////////////////////////////
+const TimeLayout = "2006-01-02 15:04:05"
+
+//////////////////////////////////////////////////////////////
+// Returns the time of the transaction proposal. This is the
+// same on every endorser, unlike time.Now()
+//////////////////////////////////////////////////////////////
+func GetTxTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
 //  Main trace chain code struct
 type TraceChainCode struct {
 
@@ -179,6 +259,64 @@ func (t *TraceChainCode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 	//myLogger.Info("[Product Trace chain code Application] Init")
 	fmt.Println("[Product Trace chain code Application] Init")
 
+	// An access policy can be set at instantiation, see trace_policy.go, and so can the
+	// configuration, see trace_config.go. Each is a JSON document, the policy is the one with Rules,
+	// and at most one of each is given. An argument that is not a JSON document is ignored
+	// peer chaincode instantiate -v 1.0 -n test_trace -p ... -c '{"Args":["init","{\"Rules\":[...]}"]}' -o orderer0:7050
+	// peer chaincode instantiate -v 1.0 -n test_trace -p ... -c '{"Args":["init","{\"Admins\":[...]}","{\"Rules\":[...]}"]}' -o orderer0:7050
+	_, args := stub.GetFunctionAndParameters()
+	var config, policy []byte
+	for _, arg := range args {
+		if !IsJSONObjectArgs([]string{arg}) {
+			continue
+		}
+		var fields map[string]json.RawMessage
+		err := json.Unmarshal([]byte(arg), &fields)
+		if err != nil {
+			fmt.Println("Init() : Failed to parse an argument : ", err)
+			return shim.Error("Init() : Failed to parse an argument : " + err.Error())
+		}
+		if _, ok := fields["Rules"]; !ok {
+			if config != nil {
+				return shim.Error("Init() : Expecting one configuration, got several")
+			}
+			config = []byte(arg)
+		} else {
+			if policy != nil {
+				return shim.Error("Init() : Expecting one access policy, got several")
+			}
+			policy = []byte(arg)
+		}
+	}
+
+	var events []ObjectEvent
+	if policy != nil {
+		buff, err := StoreAccessPolicy(stub, policy)
+		if err != nil {
+			fmt.Println("Init() : Failed to store the access policy : ", err)
+			return shim.Error("Init() : Failed to store the access policy : " + err.Error())
+		}
+		events = append(events, ObjectEvent{"AccessPolicyObj", []string{ACCESS_POLICY_KEY}, buff})
+	}
+
+	// Runs again on every upgrade, keeping the configuration on the ledger
+	buff, err := InitChaincodeConfig(stub, config)
+	if err != nil {
+		fmt.Println("Init() : Failed to store the configuration : ", err)
+		return shim.Error("Init() : Failed to store the configuration : " + err.Error())
+	}
+	if buff != nil {
+		events = append(events, ObjectEvent{"ChaincodeConfigObj", []string{CHAINCODE_CONFIG_KEY}, buff})
+	}
+
+	// A single event per transaction, one for everything Init stored
+	if len(events) > 0 {
+		err = EmitObjectBatchEvent(stub, EVENT_CHAINCODE_INITIALIZED, "", events)
+		if err != nil {
+			return shim.Error("Init() : " + err.Error())
+		}
+	}
+
 	fmt.Println("\nInit() Initialization Complete ")
 	return shim.Success(nil)
 }
@@ -216,7 +354,19 @@ func (t *TraceChainCode) invoke(stub shim.ChaincodeStubInterface, function strin
 	//////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 	InvokeRequest := InvokeFunction(function)
 	if InvokeRequest != nil {
+		err := AuthorizeFunction(stub, function)
+		if err != nil {
+			fmt.Println("Invoke() : ", err)
+			return shim.Error("Invoke : " + err.Error())
+		}
 		response := InvokeRequest(stub, args)
+		if response.Status == shim.OK {
+			// Remember when and by whom, see trace_history.go
+			err = RecordTxInfo(stub)
+			if err != nil {
+				return shim.Error("Invoke : " + err.Error())
+			}
+		}
 		return (response)
 	} else {
 		fmt.Println("Invoke() Invalid recType : ", args)
@@ -237,16 +387,21 @@ func (t *TraceChainCode) query(stub shim.ChaincodeStubInterface, function string
 
 	// var buff []byte
 	var response pb.Response
-	fmt.Println("Query() : ID Extracted and Type = ", args[0])
 	fmt.Println("Query() : Args supplied : ", args)
 
-	if len(args) < 1 {
+	// Every query takes at least 1 Key, except the ones reading a single chaincode wide record
+	if len(args) < 1 && function != "qGetAccessPolicy" && function != "qGetChaincodeConfig" {
 		fmt.Println("Query() : Include at least 1 arguments Key ")
 		return shim.Error("Query() : Expecting Transation type and Key value for query")
 	}
 
 	QueryRequest := QueryFunction(function)
 	if QueryRequest != nil {
+		err := AuthorizeFunction(stub, function)
+		if err != nil {
+			fmt.Println("Query() : ", err)
+			return shim.Error("Query() : " + err.Error())
+		}
 		response = QueryRequest(stub, args)
 	} else {
 		fmt.Println("Query() Invalid function call : ", function)
@@ -255,103 +410,14 @@ func (t *TraceChainCode) query(stub shim.ChaincodeStubInterface, function string
 	}
 
 	if response.Status != shim.OK {
-		fmt.Println("Query() Object not found : ", args[0], ",errorMsg:" + response.Message)
-		response_str := "Query() : Object not found : " + args[0] + ",errorMsg:" + response.Message
+		fmt.Println("Query() Object not found : ", args, ",errorMsg:" + response.Message)
+		response_str := "Query() : Object not found : " + strings.Join(args, ",") + ",errorMsg:" + response.Message
 		return shim.Error(response_str)
 	}
 	return response
 }
 
 
-//////////////////////////////////////////////////////////////////////////////////////////
-// Retrieve User Information by Public Key hash value
-// example:
-// peer chaincode query -n test_trace -c '{"Function": "qGetAccountInfoByAddressHash","Args":["Name"]}' -o orderer0:7050
-//////////////////////////////////////////////////////////////////////////////////////////
-
-func GetAccountInfoByAddressHash(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
-	}
-	var err error
-	// Get the Object and Display it
-	Avalbytes, err := QueryObject(stub, "AccountInfoObj", args)
-	if err != nil {
-		fmt.Println("GetAccountInfoByAddressHash() : Failed to Query Object ")
-		jsonResp := "{\"Error\":\"Failed to get  Object Data for " + args[0] + "\"}"
-		return shim.Error(jsonResp)
-	}
-
-	if Avalbytes == nil {
-		//fmt.Println("GetAccountInfoByAddressHash() : Incomplete Query Object ")
-		//jsonResp := "{\"Error\":\"Incomplete information about the key for " + args[0] + "\"}"
-		//return shim.Error(jsonResp)
-		return shim.Success(nil)
-	}
-
-	fmt.Println("GetAccountInfoByAddressHash() : Response : Successfull -")
-	return shim.Success(Avalbytes)
-}
-//////////////////////////////////////////////////////////////////////////////////////////
-// Retrieve User Information by Public Key hash value
-// example:
-// peer chaincode query -n test_trace -c '{"Function": "qGetCertificationAccountInfoByAddressHash","Args":["Name"]}' -o orderer0:7050
-////////////////////////////////////////////////////////////////////////////////////////////
-func GetCertificationAccountInfoByAddressHash(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
-	}
-	var err error
-
-	// Get the Object and Display it
-	Avalbytes, err := QueryObject(stub, "CertificationAccountInfoObj", args)
-	if err != nil {
-		fmt.Println("GetCertificationAccountInfoByAddressHash() : Failed to Query Object ")
-		jsonResp := "{\"Error\":\"Failed to get  Object Data for " + args[0] + "\"}"
-		return shim.Error(jsonResp)
-	}
-
-	if Avalbytes == nil {
-		//fmt.Println("GetCertificationAccountInfoByAddressHash() : Incomplete Query Object ")
-		//jsonResp := "{\"Error\":\"Incomplete information about the key for " + args[0] + "\"}"
-		//return shim.Error(jsonResp)
-		return shim.Success(nil)
-	}
-
-	fmt.Println("GetCertificationAccountInfoByAddressHash() : Response : Successfull -")
-	return shim.Success(Avalbytes)
-}
-
-//////////////////////////////////////////////////////////////////////////////////////////
-// Retrieve User Information by Public Key hash value
-// example:
-// peer chaincode query -l golang -n test_trace -c '{"Function": "qGetSkuBaseInfoByTraceCode", "Args": ["TraceCode"]}' -o orderer0:7050
-//
-//////////////////////////////////////////////////////////////////////////////////////////
-func GetSkuBaseInfoByTraceCode(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
-	}
-	var err error
-	// Get the Object and Display it
-	Avalbytes, err := QueryObject(stub, "SkuBaseInfoObj", args)
-	if err != nil {
-		fmt.Println("GetSkuBaseInfoByTraceCode() : Failed to Query Object ")
-		jsonResp := "{\"Error\":\"Failed to get  Object Data for " + args[0] + "\"}"
-		return shim.Error(jsonResp)
-	}
-
-	if Avalbytes == nil {
-		//fmt.Println("GetSkuBaseInfoByTraceCode() : Incomplete Query Object ")
-		//jsonResp := "{\"Error\":\"Incomplete information about the key for " + args[0] + "\"}"
-		//return shim.Error(jsonResp)
-		return shim.Success(nil)
-	}
-
-	fmt.Println("GetSkuBaseInfoByTraceCode() : Response : Successfull -")
-	return shim.Success(Avalbytes)
-}
-
 
 
 //////////////////////////////////////////////////////////
@@ -367,50 +433,17 @@ func AccountInfoToJSON(accountInfo AccountInfoObj) ([]byte, error) {
 	fmt.Println("AccountInfoToJSON created: ", ajson)
 	return ajson, nil
 }
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-// Create a normal AccountInfo Object. The first step is to have users
-// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iPostAccountInfo", "Args":["Name", "AccountType",
-// "PublicKey", "OrgName", "TimeStamp"]}'  -o orderer0:7050
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-func PostAccountInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	record, err := CreateAccountInfoObj(args[0:]) //
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	buff, err := AccountInfoToJSON(record) //
-
-	if err != nil {
-		error_str := "PostAccountInfo() : Failed Cannot create object buffer for write : " + args[1]
-		fmt.Println(error_str)
-		return shim.Error(error_str)
-	} else {
-		// Update the ledger with the Buffer Data
-		// err = stub.PutState(args[0], buff)
-		keys := []string{record.Name}
-		err = UpdateObject(stub, "AccountInfoObj", keys, buff)
-		if err != nil {
-			fmt.Println("PostAccountInfo() : write error while inserting record")
-			return shim.Error("PostAccountInfo() : write error while inserting record : Error - " + err.Error())
-		}
-
-	}
-
-	return shim.Success(buff)
-}
-
-func CreateAccountInfoObj(args []string) (AccountInfoObj, error) {
-	var account AccountInfoObj
+//////////////////////////////////////////////////////////
+// A posted or updated account keeps the history of its
+// PublicKey, see trace_keys.go
+//////////////////////////////////////////////////////////
+func RecordAccountInfoKey(stub shim.ChaincodeStubInterface, previous interface{}, object interface{}) error {
 
-	// Check there are 11 Arguments
-	if len(args) != 5 {
-		fmt.Println("CreateAccountInfoObj(): Incorrect number of arguments. Expecting 5 ")
-		return account, errors.New("CreateAccountInfoObj() : Incorrect number of arguments. Expecting 5 ")
+	var stored *AccountInfoObj
+	if previous != nil {
+		stored = previous.(*AccountInfoObj)
 	}
-
-	account = AccountInfoObj{args[0], args[1], args[2], args[3], args[4]}
-	fmt.Println("CreateAccountInfoObj() : AccountInfoObj Object : ", account)
-
-	return account, nil
+	return RecordAccountKey(stub, stored, *object.(*AccountInfoObj))
 }
 
 
@@ -427,54 +460,6 @@ func CertificationAccountInfoToJSON(accountInfo CertificationAccountInfoObj) ([]
 	fmt.Println("CertificationAccountInfoToJSON created: ", ajson)
 	return ajson, nil
 }
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-// Create a normal AccountInfo Object. The first step is to have users
-// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iPostCertificationAccountInfo", "Args":["Name", "AccountType",
-// "PublicKey", "OrgName", "TimeStamp"]}'  -o orderer0:7050
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-func PostCertificationAccountInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-
-	record, err := CreateCertificationAccountInfoObj(args[0:]) //
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	buff, err := CertificationAccountInfoToJSON(record) //
-
-	if err != nil {
-		error_str := "PostCertificationAccountInfo() : Failed Cannot create object buffer for write : " + args[1]
-		fmt.Println(error_str)
-		return shim.Error(error_str)
-	} else {
-		// Update the ledger with the Buffer Data
-		// err = stub.PutState(args[0], buff)
-		keys := []string{record.Name}
-		err = UpdateObject(stub, "CertificationAccountInfoObj", keys, buff)
-		if err != nil {
-			fmt.Println("PostCertificationAccountInfo() : write error while inserting record")
-			return shim.Error("PostCertificationAccountInfo() : write error while inserting record : Error - " + err.Error())
-		}
-	}
-
-	return shim.Success(buff)
-}
-
-func CreateCertificationAccountInfoObj(args []string) (CertificationAccountInfoObj, error) {
-
-	var account CertificationAccountInfoObj
-
-	// Check there are 11 Arguments
-	if len(args) != 5 {
-		fmt.Println("CreateCertificationAccountInfoObj(): Incorrect number of arguments. Expecting 11 ")
-		return account, errors.New("CreateCertificationAccountInfoObj() : Incorrect number of arguments. Expecting 11 ")
-	}
-
-	account = CertificationAccountInfoObj{args[0], args[1], args[2], args[3], args[4]}
-	fmt.Println("CreateCertificationAccountInfoObj() : AccountInfoObj Object : ", account)
-
-	return account, nil
-}
-
-
 
 //////////////////////////////////////////////////////////
 // Converts an SkuTransactionObj Object to a JSON String
@@ -489,76 +474,119 @@ func SkuTransactionToJSON(accountInfo SkuTransactionObj) ([]byte, error) {
 	fmt.Println("SkuTransactionToJSON created: ", ajson)
 	return ajson, nil
 }
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-// Create a normal AccountInfo Object. The first step is to have users
-// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iPostSkuTransaction", "Args":["OrderId", "SkuId","TraceCode",
-// "TransType", "BatchNum","AccountNo","Num","ExtJsonData","Signature","TransDate"]}' -o orderer0:7050
-// SkuTransactionObj key is Key: OrderId, SkuId, TraceCode,TransType
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-func PostSkuTransaction(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+//////////////////////////////////////////////////////////
+// A posted SkuTransactionObj is new, it gets the first
+// Revision and is applied to the inventory. A stored one
+// is changed with iUpdateSkuTransaction
+//////////////////////////////////////////////////////////
+func PrepareSkuTransaction(stub shim.ChaincodeStubInterface, previous interface{}, object interface{}) error {
 
-	record, err := CreateSkuTransactionObj(args[0:]) //
+	if previous != nil {
+		return ErrObjectExists
+	}
+	record := object.(*SkuTransactionObj)
+	err := CheckTransType(*record)
 	if err != nil {
-		return shim.Error(err.Error())
+		return err
 	}
-	buff, err := SkuTransactionToJSON(record) //
+	record.Revision = strconv.Itoa(SkuTransactionRevision(nil) + 1)
 
+	inventory := NewInventoryLedger(stub)
+	err = inventory.Replace(nil, record)
 	if err != nil {
-		error_str := "PostSkuTransaction() : Failed Cannot create object buffer for write : " + args[1]
-		fmt.Println(error_str)
-		return shim.Error(error_str)
-	} else {
-		// Update the ledger with the Buffer Data
-		// err = stub.PutState(args[0], buff)
-		keys := []string{record.TraceCode, record.SkuId, record.OrderId, record.TransType}
-		err = UpdateObject(stub, "SkuTransactionObj", keys, buff)
-		if err != nil {
-			fmt.Println("PostSkuTransaction() : write error while inserting record")
-			return shim.Error("PostSkuTransaction() : write error while inserting record : Error - " + err.Error())
-		}
+		return err
 	}
-	return shim.Success(buff)
+	return inventory.Flush()
 }
+
 func PostSkuTransactionArrary(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
 	records, err := CreateSkuTransactionObjArrary(args[0:]) //
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	// Writes are not visible to reads within the same transaction,
+	// so records repeated in the array are tracked here
+	written := make(map[string]bool)
+	inventory := NewInventoryLedger(stub)
+	var events []ObjectEvent
 	for i := range records {
 		var record = records[i];
+		keys := []string{record.TraceCode, record.SkuId, record.OrderId, record.TransType}
+		if written[strings.Join(keys, ",")] {
+			return Conflict(fmt.Sprintf("PostSkuTransactionArrary() : record %d : SkuTransactionObj %s is repeated in the array", i, strings.Join(keys, ",")))
+		}
+		previous, err := GetSkuTransaction(stub, keys)
+		if err != nil {
+			return shim.Error("PostSkuTransaction() : " + err.Error())
+		}
+		if previous != nil {
+			return Conflict(fmt.Sprintf("PostSkuTransactionArrary() : record %d : SkuTransactionObj %s is already posted, update it with iUpdateSkuTransaction", i, strings.Join(keys, ",")))
+		}
+		record.Revision = strconv.Itoa(SkuTransactionRevision(nil) + 1)
+		err = inventory.Replace(nil, &record)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("PostSkuTransactionArrary() : record %d : %s", i, err))
+		}
+		written[strings.Join(keys, ",")] = true
 		buff, err := SkuTransactionToJSON(record) //
 
 		if err != nil {
-			error_str := "PostSkuTransaction() : Failed Cannot create object buffer for write : " + args[1]
+			error_str := "PostSkuTransaction() : Failed Cannot create object buffer for write : " + record.TraceCode
 			fmt.Println(error_str)
 			return shim.Error(error_str)
 		} else {
 			// Update the ledger with the Buffer Data
 			// err = stub.PutState(args[0], buff)
-			keys := []string{record.TraceCode, record.SkuId, record.OrderId, record.TransType}
 			err = UpdateObject(stub, "SkuTransactionObj", keys, buff)
 			if err != nil {
 				fmt.Println("PostSkuTransaction() : write error while inserting record")
 				return shim.Error("PostSkuTransaction() : write error while inserting record : Error - " + err.Error())
 			}
+			events = append(events, ObjectEvent{"SkuTransactionObj", keys, buff})
 		}
 	}
 
+	err = inventory.Flush()
+	if err != nil {
+		return shim.Error("PostSkuTransactionArrary() : " + err.Error())
+	}
+	err = EmitObjectBatchEvent(stub, EVENT_TRANSACTIONS_POSTED, "SkuTransactionObj", events)
+	if err != nil {
+		return shim.Error("PostSkuTransactionArrary() : " + err.Error())
+	}
+
 	return shim.Success([]byte("OK"))
 }
 
-func CreateSkuTransactionObj(args []string) (SkuTransactionObj, error) {
+//////////////////////////////////////////////////////////
+// Returns the SkuTransactionObj stored under keys, nil if
+// there is none
+//////////////////////////////////////////////////////////
+func GetSkuTransaction(stub shim.ChaincodeStubInterface, keys []string) (*SkuTransactionObj, error) {
 
-	var record SkuTransactionObj
-	// Check there are 10 Arguments
-	if len(args) != 10 {
-		fmt.Println("CreateSkuTransactionObj(): Incorrect number of arguments. Expecting 10 ")
-		return record, errors.New("CreateSkuTransactionObj() : Incorrect number of arguments. Expecting 10 ")
+	Avalbytes, err := QueryObject(stub, "SkuTransactionObj", keys)
+	if err != nil || Avalbytes == nil {
+		return nil, err
+	}
+	acc, err := JSONtoSkuTransactionObj(Avalbytes)
+	if err != nil {
+		return nil, err
 	}
-	record = SkuTransactionObj{args[0], args[1], args[2], args[3], args[4],args[5], args[6], args[7], args[8], args[9]}
-	fmt.Println("CreateSkuTransactionObj() : SkuTransactionObj Object : ", record)
-	return record, nil
+	return &acc, nil
+}
+
+//////////////////////////////////////////////////////////
+// Returns the Revision of a stored SkuTransactionObj, 0
+// if there is none
+//////////////////////////////////////////////////////////
+func SkuTransactionRevision(record *SkuTransactionObj) int {
+
+	if record == nil {
+		return 0
+	}
+	revision, _ := strconv.Atoi(record.Revision)
+	return revision
 }
 
 func CreateSkuTransactionObjArrary(args []string) ([]SkuTransactionObj, error) {
@@ -569,153 +597,86 @@ func CreateSkuTransactionObjArrary(args []string) ([]SkuTransactionObj, error) {
 		fmt.Println("CreateSkuTransactionObjArrary(): Incorrect number of arguments. Expecting 1 ")
 		return records, errors.New("CreateSkuTransactionObjArrary(): Incorrect number of arguments. Expecting 1")
 	}
-	err := json.Unmarshal([]byte(args[0]),&records)
-	if (err!=nil) {
+	// Each element is checked like a single JSON document, see JSONtoObject
+	var raw []json.RawMessage
+	err := json.Unmarshal([]byte(args[0]), &raw)
+	if err != nil {
 		fmt.Println("Unmarshal to []SkuTransactionObj : ", args[0])
-		return records, errors.New("Unmarshal to []SkuTransactionObj : "+args[0])
+		return records, errors.New("Unmarshal to []SkuTransactionObj : " + err.Error())
+	}
+	records = make([]SkuTransactionObj, len(raw))
+	for i := range raw {
+		err = JSONtoObject("SkuTransactionObj", raw[i], &records[i], RequiredFields("SkuTransactionObj"))
+		if err != nil {
+			return nil, fmt.Errorf("CreateSkuTransactionObjArrary(): record %d : %s", i, err)
+		}
+		err = CheckTransType(records[i])
+		if err != nil {
+			return nil, fmt.Errorf("CreateSkuTransactionObjArrary(): record %d : %s", i, err)
+		}
 	}
 	return records, nil
 }
 
 
 //////////////////////////////////////////////////////////
-// Converts an SkuBaseInfoObj Object to a JSON String
+// Status of an authentication, reported by
+// qGetSkuAuthenticationRecordListByTraceCode
 //////////////////////////////////////////////////////////
-func SkuBaseInfoToJSON(accountInfo SkuBaseInfoObj) ([]byte, error) {
-
-	ajson, err := json.Marshal(accountInfo)
-	if err != nil {
-		fmt.Println("SkuTransactionToJSON error: ", err)
-		return nil, err
-	}
-	fmt.Println("SkuTransactionToJSON created: ", ajson)
-	return ajson, nil
-}
-
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-// Create a normal AccountInfo Object. The first step is to have users
-// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iPostSkuBaseInfo", "Args":["SkuId", "VendorCode","TraceCode",
-// "AddressHash", "Name","BatchNum","ExtJsonData","Signature","TimeStamp"]}' -o orderer0:7050
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+const (
+	AUTHENTICATION_VALID         = "VALID"
+	AUTHENTICATION_NOT_YET_VALID = "NOT_YET_VALID"
+	AUTHENTICATION_EXPIRED       = "EXPIRED"
+	AUTHENTICATION_REVOKED       = CERTIFICATION_REVOKED
+	AUTHENTICATION_SUSPENDED     = CERTIFICATION_SUSPENDED
+)
 
-func PostSkuBaseInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+//////////////////////////////////////////////////////////
+// Returns the status of an authentication at time now
+//////////////////////////////////////////////////////////
+func SkuAuthenticationStatus(record SkuAuthenticationTraceRecordObj, now time.Time) string {
 
-	record, err := CreateSkuBaseInfoObj(args[0:]) //
-	if err != nil {
-		return shim.Error(err.Error())
+	beginTime, _ := time.Parse(TimeLayout, record.BeginTime)
+	endTime, err := time.Parse(TimeLayout, record.EndTime)
+	if err != nil || now.After(endTime) {
+		return AUTHENTICATION_EXPIRED
 	}
-	buff, err := SkuBaseInfoToJSON(record) //
-
-	if err != nil {
-		error_str := "PostSkuBaseInfo() : Failed Cannot create object buffer for write : " + args[1]
-		fmt.Println(error_str)
-		return shim.Error(error_str)
-	} else {
-		// Update the ledger with the Buffer Data
-		//keys := []string{record.TraceCode, record.SkuId, record.VendorCode}
-		keys := []string{record.SkuId}
-		err = UpdateObject(stub, "SkuBaseInfoObj", keys, buff)
-		if err != nil {
-			fmt.Println("PostSkuBaseInfo() : write error while inserting record")
-			return shim.Error("PostSkuBaseInfo() : write error while inserting record : Error - " + err.Error())
-		}
+	if now.Before(beginTime) {
+		return AUTHENTICATION_NOT_YET_VALID
 	}
-	return shim.Success(buff)
+	return AUTHENTICATION_VALID
 }
 
-func PostTransactionId(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+//////////////////////////////////////////////////////////
+// Check the certification body signature and the validity
+// window of a SkuAuthenticationTraceRecordObj
+//////////////////////////////////////////////////////////
+func VerifySkuAuthenticationTraceRecord(stub shim.ChaincodeStubInterface, record SkuAuthenticationTraceRecordObj) error {
 
-	record, err := CreateSkuBaseInfoObj(args[0:]) //
+	beginTime, err := time.Parse(TimeLayout, record.BeginTime)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errors.New("VerifySkuAuthenticationTraceRecord() : BeginTime is not a valid time : " + record.BeginTime)
 	}
-	buff, err := SkuBaseInfoToJSON(record) //
-
+	endTime, err := time.Parse(TimeLayout, record.EndTime)
 	if err != nil {
-		error_str := "PostSkuBaseInfo() : Failed Cannot create object buffer for write : " + args[1]
-		fmt.Println(error_str)
-		return shim.Error(error_str)
-	} else {
-		// Update the ledger with the Buffer Data
-		//keys := []string{record.TraceCode, record.SkuId, record.VendorCode}
-		keys := []string{record.TraceCode}
-		err = UpdateObject(stub, "SkuBaseInfoObj", keys, buff)
-		if err != nil {
-			fmt.Println("PostSkuBaseInfo() : write error while inserting record")
-			return shim.Error("PostSkuBaseInfo() : write error while inserting record : Error - " + err.Error())
-		}
+		return errors.New("VerifySkuAuthenticationTraceRecord() : EndTime is not a valid time : " + record.EndTime)
 	}
-	return shim.Success(buff)
-}
-
-func CreateSkuBaseInfoObj(args []string) (SkuBaseInfoObj, error) {
-
-	var record SkuBaseInfoObj
-	// Check there are 11 Arguments
-	if len(args) != 9 {
-		fmt.Println("CreateSkuBaseInfoObj(): Incorrect number of arguments. Expecting 11 ")
-		return record, errors.New("CreateSkuBaseInfoObj() : Incorrect number of arguments. Expecting 9 ")
+	if endTime.Before(beginTime) {
+		return errors.New("VerifySkuAuthenticationTraceRecord() : EndTime is before BeginTime")
 	}
-	record = SkuBaseInfoObj{args[0], args[1], args[2], args[3], args[4],args[5], args[6], args[7], args[8]}
-	fmt.Println("CreateSkuBaseInfoObj() : SkuBaseInfoObj Object : ", record)
-	return record, nil
-}
-
-
-//////////////////////////////////////////////////////////
-// Converts an SkuAuthenticationTraceRecordObj Object to a JSON String
-//////////////////////////////////////////////////////////
-func SkuSkuAuthenticationTraceRecordToJSON(accountInfo SkuAuthenticationTraceRecordObj) ([]byte, error) {
-
-	ajson, err := json.Marshal(accountInfo)
+	now, err := GetTxTime(stub)
 	if err != nil {
-		fmt.Println("SkuSkuAuthenticationTraceRecordToJSON error: ", err)
-		return nil, err
+		return err
 	}
-	fmt.Println("SkuSkuAuthenticationTraceRecordToJSON created: ", ajson)
-	return ajson, nil
-}
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-// Create a normal AccountInfo Object. The first step is to have users
-// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iPostSkuAuthenticationTraceRecord", "Args":["SkuId", "AddressHash",
-// "TraceCode", "CertificationBodyType", "BatchNum","CertificationBodyName","Signature","ExtJsonData","BeginTime","EndTime","TimeStamp"]}' -o orderer0:7050
-// SkuAuthenticationTraceRecordObj key is Key: SkuId,AddressHash,TraceCode,CertificationBodyType
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-func PostSkuAuthenticationTraceRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-
-	record, err := CreateSkuAuthenticationTraceRecordObj(args[0:]) //
-	if err != nil {
-		return shim.Error(err.Error())
+	if SkuAuthenticationStatus(record, now) == AUTHENTICATION_EXPIRED {
+		return errors.New("VerifySkuAuthenticationTraceRecord() : AUTHENTICATION_EXPIRED : EndTime " + record.EndTime + " has passed")
 	}
-	buff, err := SkuSkuAuthenticationTraceRecordToJSON(record) //
 
-	if err != nil {
-		error_str := "PostSkuAuthenticationTraceRecord() : Failed Cannot create object buffer for write : " + args[1]
-		fmt.Println(error_str)
-		return shim.Error(error_str)
-	} else {
-		// Update the ledger with the Buffer Data
-		keys := []string{record.TraceCode, record.SkuId, record.AddressHash, record.CertificationBodyType}
-		err = UpdateObject(stub, "SkuAuthenticationTraceRecordObj", keys, buff)
-		if err != nil {
-			fmt.Println("PostSkuAuthenticationTraceRecord() : write error while inserting record")
-			return shim.Error("PostSkuAuthenticationTraceRecord() : write error while inserting record : Error - " + err.Error())
-		}
-	}
-	return shim.Success(buff)
+	return VerifySkuAuthenticationTraceRecordSignature(stub, record)
 }
 
-func CreateSkuAuthenticationTraceRecordObj(args []string) (SkuAuthenticationTraceRecordObj, error) {
-
-	var record SkuAuthenticationTraceRecordObj
-	// Check there are 11 Arguments
-	if len(args) != 11{
-		fmt.Println("CreateSkuAuthenticationTraceRecordObj(): Incorrect number of arguments. Expecting 11 ")
-		return record, errors.New("CreateSkuAuthenticationTraceRecordObj() : Incorrect number of arguments. Expecting 11 ")
-	}
-	record = SkuAuthenticationTraceRecordObj{args[0], args[1], args[2], args[3], args[4],args[5], args[6], args[7], args[8],args[9], args[10]}
-	fmt.Println("CreateSkuAuthenticationTraceRecordObj() : SkuAuthenticationTraceRecordObj Object : ", record)
-	return record, nil
+func ValidateSkuAuthenticationTraceRecord(stub shim.ChaincodeStubInterface, object interface{}) error {
+	return VerifySkuAuthenticationTraceRecord(stub, *object.(*SkuAuthenticationTraceRecordObj))
 }
 
 //////////////////////////////////////////////////////////
@@ -731,36 +692,23 @@ func SkuTraceRecordToJSON(accountInfo SkuTraceRecordObj) ([]byte, error) {
 	fmt.Println("SkuTraceRecordToJSON created: ", ajson)
 	return ajson, nil
 }
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-// Create a normal AccountInfo Object. The first step is to have users
-// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iPostSkuTraceRecord", "Args":["SkuId", "AddressHash",
-// "TraceCode", "StationType", "BatchNum","StationName","ExpressNum","Signature","PreStation","NextStation","ExtJsonData",
-// "BeginTime","EndTime","TimeStamp"]}' -o orderer0:7050
-// SkuTraceRecordObj key is Key: SkuId,AddressHash,TraceCode,StationType
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func PostSkuTraceRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+func ValidateSkuTraceRecord(stub shim.ChaincodeStubInterface, object interface{}) error {
+	return VerifyPostedSkuTraceRecordSignature(stub, *object.(*SkuTraceRecordObj))
+}
 
-	record, err := CreateSkuTraceRecordObj(args[0:]) //
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	buff, err := SkuTraceRecordToJSON(record) //
+func CheckStationContinuity(stub shim.ChaincodeStubInterface, previous interface{}, object interface{}) error {
 
+	record := object.(*SkuTraceRecordObj)
+	if stored, ok := previous.(*SkuTraceRecordObj); ok && stored != nil && SameStation(*stored, *record) {
+		record.Discontinuity = stored.Discontinuity
+		return nil
+	}
+	checker, err := NewStationContinuityChecker(stub)
 	if err != nil {
-		error_str := "PostSkuTraceRecord() : Failed Cannot create object buffer for write : " + args[1]
-		fmt.Println(error_str)
-		return shim.Error(error_str)
-	} else {
-		// Update the ledger with the Buffer Data
-		keys := []string{record.TraceCode, record.SkuId, record.AddressHash, record.StationType}
-		err = UpdateObject(stub, "SkuTraceRecordObj", keys, buff)
-		if err != nil {
-			fmt.Println("PostSkuTraceRecord() : write error while inserting record")
-			return shim.Error("PostSkuTraceRecord() : write error while inserting record : Error - " + err.Error())
-		}
+		return err
 	}
-	return shim.Success(buff)
+	return checker.Check(record)
 }
 
 func PostSkuTraceRecordArray(stub shim.ChaincodeStubInterface, args []string) pb.Response {
@@ -769,40 +717,54 @@ func PostSkuTraceRecordArray(stub shim.ChaincodeStubInterface, args []string) pb
 	if err != nil {
 		return shim.Error(err.Error())
 	}
+	checker, err := NewStationContinuityChecker(stub)
+	if err != nil {
+		return shim.Error("PostSkuTraceRecordArray() : " + err.Error())
+	}
+	// Writes are not visible to reads within the same transaction, a record
+	// repeated in the array would leave the index entries of the first behind
+	written := make(map[string]bool)
+	var events []ObjectEvent
 	for i := range records{
 	    var record = records[i];
+		keys := []string{record.TraceCode, record.SkuId, record.AddressHash, record.StationType}
+		if written[strings.Join(keys, ",")] {
+			return Conflict(fmt.Sprintf("PostSkuTraceRecordArray() : record %d : SkuTraceRecordObj %s is repeated in the array", i, strings.Join(keys, ",")))
+		}
+		written[strings.Join(keys, ",")] = true
+		err = VerifyPostedSkuTraceRecordSignature(stub, record)
+		if err != nil {
+			fmt.Println("PostSkuTraceRecordArray() : signature verification failed : ", err)
+			return shim.Error(fmt.Sprintf("PostSkuTraceRecordArray() : record %d : %s", i, err))
+		}
+		err = checker.Check(&record)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("PostSkuTraceRecordArray() : record %d : %s", i, err))
+		}
 		buff, err := SkuTraceRecordToJSON(record) //
 
 		if err != nil {
-			error_str := "PostSkuTraceRecord() : Failed Cannot create object buffer for write : " + args[1]
+			error_str := "PostSkuTraceRecord() : Failed Cannot create object buffer for write : " + record.TraceCode
 			fmt.Println(error_str)
 			return shim.Error(error_str)
 		} else {
 			// Update the ledger with the Buffer Data
-			keys := []string{record.TraceCode, record.SkuId, record.AddressHash, record.StationType}
 			err = UpdateObject(stub, "SkuTraceRecordObj", keys, buff)
 			if err != nil {
 				fmt.Println("PostSkuTraceRecord() : write error while inserting record")
 				return shim.Error("PostSkuTraceRecord() : write error while inserting record : Error - " + err.Error())
 			}
+			events = append(events, ObjectEvent{"SkuTraceRecordObj", keys, buff})
 		}
 	}
 
 
-	return shim.Success([]byte("OK"))
-}
-
-func CreateSkuTraceRecordObj(args []string) (SkuTraceRecordObj, error) {
-
-	var record SkuTraceRecordObj
-	// Check there are 11 Arguments
-	if len(args) != 14{
-		fmt.Println("CreateSkuTraceRecordObj(): Incorrect number of arguments. Expecting 14 ")
-		return record, errors.New("CreateSkuTraceRecordObj() : Incorrect number of arguments. Expecting 14 ")
+	err = EmitObjectBatchEvent(stub, EVENT_TRACE_RECORDS_POSTED, "SkuTraceRecordObj", events)
+	if err != nil {
+		return shim.Error("PostSkuTraceRecordArray() : " + err.Error())
 	}
-	record = SkuTraceRecordObj{args[0], args[1], args[2], args[3], args[4],args[5], args[6], args[7], args[8],args[9], args[10],args[11],args[12], args[13]}
-	fmt.Println("CreateSkuTraceRecordObj() : SkuTraceRecordObj Object : ", record)
-	return record, nil
+
+	return shim.Success([]byte("OK"))
 }
 
 func CreateSkuTraceRecordObjArray(args []string) ([]SkuTraceRecordObj, error) {
@@ -813,10 +775,19 @@ func CreateSkuTraceRecordObjArray(args []string) ([]SkuTraceRecordObj, error) {
 		fmt.Println("CreateSkuTraceRecordObjArray(): Incorrect number of arguments. Expecting 1 ")
 		return records, errors.New("CreateSkuTraceRecordObjArray() : Incorrect number of arguments. Expecting 1 ")
 	}
-	err := json.Unmarshal([]byte(args[0]),&records)
-	if (err!=nil) {
+	// Each element is checked like a single JSON document, see JSONtoObject
+	var raw []json.RawMessage
+	err := json.Unmarshal([]byte(args[0]), &raw)
+	if err != nil {
 		fmt.Println("Unmarshal to []CreateSkuTraceRecordObj : ", args[0])
-		return records, errors.New("Unmarshal to []CreateSkuTraceRecordObj : "+args[0])
+		return records, errors.New("Unmarshal to []CreateSkuTraceRecordObj : " + err.Error())
+	}
+	records = make([]SkuTraceRecordObj, len(raw))
+	for i := range raw {
+		err = JSONtoObject("SkuTraceRecordObj", raw[i], &records[i], RequiredFields("SkuTraceRecordObj"))
+		if err != nil {
+			return nil, fmt.Errorf("CreateSkuTraceRecordObjArray(): record %d : %s", i, err)
+		}
 	}
 	return records, nil
 }
@@ -835,44 +806,6 @@ func JSONtoAccountInfoObj(areq []byte) (AccountInfoObj, error) {
 	return ar, err
 }
 
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-// This function updates the AccountInfoObj
-// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iUpdateAccountInfo", "Args":["Name", "AccountType",
-// "PublicKey", "OrgName", "TimeStamp"]}' -o orderer0:7050
-
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-func UpdateAccountInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-
-	// Fetch Auction Object and check its Status
-	Avalbytes, err := QueryObject(stub, "AccountInfoObj", []string{args[0]})
-	if err != nil {
-		fmt.Println("UpdateAccountInfo(): Auction Object Retrieval Failed ")
-		return shim.Error("OpenAuctionForBids(): Auction Object Retrieval Failed ")
-	}
-
-	acc, err := JSONtoAccountInfoObj(Avalbytes)
-	if err != nil {
-		fmt.Println("UpdateAccountInfo(): Auction Object Unmarshalling Failed ")
-		return shim.Error("UpdateAccountInfo(): Auction Object UnMarshalling Failed ")
-	}
-
-	acc.Name = args[0]
-	acc.AccountType = args[1]
-	acc.PublicKey = args[2]
-	acc.OrgName = args[3]
-	aucStartDate, err := time.Parse("2006-01-02 15:04:05", args[4])
-	acc.TimeStamp = aucStartDate.Format("2006-01-02 15:04:05") // This is the time stamp
-
-
-	response := ReplaceAccountInfoObj(stub, "AccountInfoObj", acc)
-	if response.Status != shim.OK {
-		fmt.Println("UpdateAccountInfo(): ReplaceAccountInfoObj() Failed ")
-		return shim.Error("UpdateAccountInfo(): ReplaceAccountInfoObj() Failed ")
-	}
-	buff := response.Payload
-
-	return shim.Success(buff)
-}
 func ReplaceAccountInfoObj(stub shim.ChaincodeStubInterface, tableName string, ar AccountInfoObj) pb.Response {
 
 	buff, err := AccountInfoToJSON(ar)
@@ -906,46 +839,6 @@ func JSONtoCertificationAccountInfoObj(areq []byte) (CertificationAccountInfoObj
 	}
 	return ar, err
 }
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-// This function updates the CertificationAccountInfoObj
-// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iUpdateCertificationAccountInfo", "Args":["Name", "AccountType",
-// "PublicKey", "OrgName", "TimeStamp"]}' -o orderer0:7050
-
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-
-func UpdateCertificationAccountInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-
-	// Fetch Auction Object and check its Status
-	Avalbytes, err := QueryObject(stub, "CertificationAccountInfoObj", []string{args[0]})
-	if err != nil {
-		fmt.Println("UpdateCertificationAccountInfo(): Auction Object Retrieval Failed ")
-		return shim.Error("UpdateCertificationAccountInfo(): Auction Object Retrieval Failed ")
-	}
-
-	acc, err := JSONtoCertificationAccountInfoObj(Avalbytes)
-	if err != nil {
-		fmt.Println("UpdateCertificationAccountInfo(): Auction Object Unmarshalling Failed ")
-		return shim.Error("UpdateCertificationAccountInfo(): Auction Object UnMarshalling Failed ")
-	}
-
-	acc.Name = args[0]
-	acc.AccountType = args[1]
-	acc.PublicKey = args[2]
-
-	acc.OrgName = args[3]
-	aucStartDate, err := time.Parse("2006-01-02 15:04:05", args[4])
-	acc.TimeStamp = aucStartDate.Format("2006-01-02 15:04:05") // This is the time stamp
-
-
-	response := ReplaceCertificationAccountInfoObj(stub, "AccountInfoObj", acc)
-	if response.Status != shim.OK {
-		fmt.Println("UpdateCertificationAccountInfo(): ReplaceCertificationAccountInfoObj() Failed ")
-		return shim.Error("UpdateCertificationAccountInfo(): ReplaceCertificationAccountInfoObj() Failed ")
-	}
-	buff := response.Payload
-
-	return shim.Success(buff)
-}
 func ReplaceCertificationAccountInfoObj(stub shim.ChaincodeStubInterface, tableName string, ar CertificationAccountInfoObj) pb.Response {
 
 	buff, err := CertificationAccountInfoToJSON(ar)
@@ -981,63 +874,118 @@ func JSONtoSkuBaseInfoObj(areq []byte) (SkuBaseInfoObj, error) {
 }
 
 
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-// This function updates the SkuBaseInfoObj
-// peer chaincode invoke -l golang -n test_trace -c '{"Function": "UpdateSkuBaseInfo", "Args":["SkuId", "VendorCode","TraceCode",
-// "AddressHash", "Name","BatchNum","ExtJsonData","Signature","TimeStamp"]}' -o orderer0:7050
-// SkuBaseInfoObj key is Key: SkuId,VendorCode,TraceCode
 
+//////////////////////////////////////////////////////////
+// Converts an User Object to a JSON String
+//////////////////////////////////////////////////////////
+func JSONtoSkuTransactionObj(areq []byte) (SkuTransactionObj, error) {
+
+	ar := SkuTransactionObj{}
+	err := json.Unmarshal(areq, &ar)
+	if err != nil {
+		fmt.Println("JSONtoSkuTransactionObj error: ", err)
+		return ar, err
+	}
+	return ar, err
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Update the SkuTransactionObj Object
+// The record is addressed by the same key PostSkuTransaction writes and only the attributes in the
+// JSON patch are changed. The patch must carry the Revision it was prepared against, an update
+// prepared against an older revision is rejected with CONFLICT
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iUpdateSkuTransaction", "Args":["TraceCode", "SkuId",
+// "OrderId", "TransType", "{\"Num\":\"Num\",\"Revision\":\"1\"}"]}' -o orderer0:7050
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-func UpdateSkuBaseInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 
-	// Fetch Auction Object and check its Status
-	Avalbytes, err := QueryObject(stub, "SkuBaseInfoObj", []string{args[0]})
+func UpdateSkuTransaction(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 5 {
+		return shim.Error("UpdateSkuTransaction(): Incorrect number of arguments. Expecting TraceCode, SkuId, OrderId, TransType and a JSON patch")
+	}
+	keys := args[0:4]
+
+	Avalbytes, err := QueryObject(stub, "SkuTransactionObj", keys)
 	if err != nil {
-		fmt.Println("UpdateSkuBaseInfo(): Auction Object Retrieval Failed ")
-		return shim.Error("UpdateSkuBaseInfo(): Auction Object Retrieval Failed ")
+		fmt.Println("UpdateSkuTransaction(): Object Retrieval Failed ")
+		return shim.Error("UpdateSkuTransaction(): Object Retrieval Failed : " + err.Error())
+	}
+	if Avalbytes == nil {
+		return NotFound("UpdateSkuTransaction(): SkuTransactionObj " + strings.Join(keys, ","))
 	}
 
-	acc, err := JSONtoSkuBaseInfoObj(Avalbytes)
+	acc, err := JSONtoSkuTransactionObj(Avalbytes)
 	if err != nil {
-		fmt.Println("UpdateSkuBaseInfo(): Auction Object Unmarshalling Failed ")
-		return shim.Error("UpdateSkuBaseInfo(): Auction Object UnMarshalling Failed ")
+		fmt.Println("UpdateSkuTransaction(): Object Unmarshalling Failed ")
+		return shim.Error("UpdateSkuTransaction(): Object UnMarshalling Failed ")
 	}
-	acc.SkuId  = args[0]
-	acc.VendorCode = args[1]
-	acc.TraceCode = args[2]
-	acc.AddressHash   =args[3]
-	acc.Name  = args[4]
-	acc.BatchNum  = args[5]
+	err = CheckTransType(acc)
+	if err != nil {
+		return shim.Error("UpdateSkuTransaction(): " + err.Error())
+	}
+	revision, _ := strconv.Atoi(acc.Revision)
 
-	acc.ExtJsonData=args[6]
-	acc.Signature=args[7]
+	var patch struct{ Revision *string }
+	err = json.Unmarshal([]byte(args[4]), &patch)
+	if err != nil {
+		return shim.Error("UpdateSkuTransaction(): the JSON patch is not valid : " + err.Error())
+	}
+	if patch.Revision == nil {
+		return shim.Error("UpdateSkuTransaction(): the JSON patch must carry the Revision it was prepared against")
+	}
+	expected, err := strconv.Atoi(*patch.Revision)
+	if err != nil && *patch.Revision != "" {
+		return shim.Error("UpdateSkuTransaction(): Revision must be a number : " + *patch.Revision)
+	}
+	if expected != revision {
+		return Conflict(fmt.Sprintf("UpdateSkuTransaction(): SkuTransactionObj %s is at revision %d, update was prepared against revision %d",
+			strings.Join(keys, ","), revision, expected))
+	}
 
-	aucStartDate, err := time.Parse("2006-01-02 15:04:05", args[8])
-	acc.TimeStamp = aucStartDate.Format("2006-01-02 15:04:05") // This is the time stamp
+	previous := acc
+	err = PatchObject("SkuTransactionObj", &acc, []byte(args[4]), KeyFields("SkuTransactionObj"))
+	if err != nil {
+		return shim.Error("UpdateSkuTransaction(): " + err.Error())
+	}
+	acc.Revision = strconv.Itoa(revision + 1)
 
+	// Take back the effect on the inventory before the update and apply the new one
+	inventory := NewInventoryLedger(stub)
+	err = inventory.Replace(&previous, &acc)
+	if err != nil {
+		return shim.Error("UpdateSkuTransaction(): " + err.Error())
+	}
+	err = inventory.Flush()
+	if err != nil {
+		return shim.Error("UpdateSkuTransaction(): " + err.Error())
+	}
 
-	response := ReplaceSkuBaseInfoObj(stub, "SkuBaseInfoObj", acc)
+	response := ReplaceSkuTransactionObj(stub, "SkuTransactionObj", acc)
 	if response.Status != shim.OK {
-		fmt.Println("UpdateSkuBaseInfo(): ReplaceSkuBaseInfoObj() Failed ")
-		return shim.Error("UpdateSkuBaseInfo(): ReplaceSkuBaseInfoObj() Failed ")
+		fmt.Println("UpdateSkuTransaction(): ReplaceSkuTransactionObj() Failed ")
+		return shim.Error("UpdateSkuTransaction(): ReplaceSkuTransactionObj() Failed ")
 	}
 	buff := response.Payload
+	err = EmitObjectEvent(stub, EVENT_TRANSACTION_UPDATED, "SkuTransactionObj", keys, buff)
+	if err != nil {
+		return shim.Error("UpdateSkuTransaction(): " + err.Error())
+	}
 
 	return shim.Success(buff)
 }
 
-func ReplaceSkuBaseInfoObj(stub shim.ChaincodeStubInterface, tableName string, ar SkuBaseInfoObj) pb.Response {
+func ReplaceSkuTransactionObj(stub shim.ChaincodeStubInterface, tableName string, ar SkuTransactionObj) pb.Response {
 
-	buff, err := SkuBaseInfoToJSON(ar)
+	buff, err := SkuTransactionToJSON(ar)
 	if err != nil {
-		fmt.Println("ReplaceSkuBaseInfoObj() : Failed Cannot create object buffer for write : ", ar.Name)
-		return shim.Error("ReplaceSkuBaseInfoObj(): Failed Cannot create object buffer for write : " + ar.Name)
+		fmt.Println("ReplaceSkuTransactionObj() : Failed Cannot create object buffer for write : ", ar.TraceCode)
+		return shim.Error("ReplaceSkuTransactionObj(): Failed Cannot create object buffer for write : " + ar.TraceCode)
 	}
 	// Update the ledger with the Buffer Data
-	keys := []string{ar.TraceCode}
+	keys := []string{ar.TraceCode, ar.SkuId, ar.OrderId, ar.TransType}
 	err = ReplaceObject(stub, tableName, keys, buff)
 	if err != nil {
-		fmt.Println("ReplaceSkuBaseInfoObj() : write error while inserting record")
+		fmt.Println("ReplaceSkuTransactionObj() : write error while inserting record")
 		return shim.Error(err.Error())
 	}
 	return shim.Success(buff)
@@ -1045,94 +993,76 @@ func ReplaceSkuBaseInfoObj(stub shim.ChaincodeStubInterface, tableName string, a
 
 
 //////////////////////////////////////////////////////////
-// Converts an User Object to a JSON String
+// A page of a list query, returned instead of the plain
+// list when a pageSize or includeDeleted is given
 //////////////////////////////////////////////////////////
-func JSONtoSkuTransactionObj(areq []byte) (SkuTransactionObj, error) {
-
-	ar := SkuTransactionObj{}
-	err := json.Unmarshal(areq, &ar)
-	if err != nil {
-		fmt.Println("JSONtoSkuTransactionObj error: ", err)
-		return ar, err
-	}
-	return ar, err
+type ListPage struct {
+	Records        interface{}
+	RecordCount    int
+	Bookmark       string // Pass back to get the next page, empty on the last page
+	Tombstones     []json.RawMessage `json:",omitempty"` // Deleted records, with includeDeleted
 }
 
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-// Update the SkuTransactionObj Object
-// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iUpdateSkuTransaction", "Args":["OrderId", "SkuId","TraceCode",
-// "TransType", "BatchNum","AccountNo","Num","ExtJsonData","Signature","TransDate"]}' -o orderer0:7050
-
-///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
-
-func UpdateSkuTransaction(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-
-	// Fetch Auction Object and check its Status
-	//Avalbytes, err := QueryObject(stub, "SkuTransactionObj", []string{args[0]})
-	//if err != nil {
-	//	fmt.Println("UpdateSkuTransaction(): Auction Object Retrieval Failed ")
-	//	return shim.Error("UpdateSkuTransaction(): Auction Object Retrieval Failed ")
-	//}
-	//
-	//acc, err := JSONtoSkuTransactionObj(Avalbytes)
-	//if err != nil {
-	//	fmt.Println("UpdateSkuTransaction(): Auction Object Unmarshalling Failed ")
-	//	return shim.Error("UpdateSkuTransaction(): Auction Object UnMarshalling Failed ")
-	//}
-	//
-	//
-	//acc.OrderId  = args[0]
-	//acc.SkuId  = args[1]
-	//acc.TraceCode = args[2]
-	//acc.TransType = args[3]
-	//acc.BatchNum   =args[4]
-	//acc.AccountNo  = args[5]
-	//acc.Num=args[6]
-	//acc.ExtJsonData=args[7]
-	//acc.Signature=args[8]
-	//
-	//aucStartDate, err := time.Parse("2006-01-02 15:04:05", args[9])
-	//acc.TransDate=aucStartDate.Format("2006-01-02 15:04:05")
-	//
-	//
-	//response := ReplaceSkuTransactionObj(stub, "SkuTransactionObj", acc)
-	//if response.Status != shim.OK {
-	//	fmt.Println("UpdateSkuTransaction(): ReplaceSkuTransactionObj() Failed ")
-	//	return shim.Error("UpdateSkuTransaction(): ReplaceSkuTransactionObj() Failed ")
-	//}
-	//buff := response.Payload
-	//
-	//return shim.Success(buff)
-
-	return shim.Success(nil)
-}
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+// Read the values of a list query by TraceCode
+// args: TraceCode [, pageSize [, bookmark]] [, includeDeleted]
+// Without pageSize the whole list is read, and the returned page is nil unless
+// includeDeleted is given
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+func GetListByTraceCode(stub shim.ChaincodeStubInterface, objectType string, args []string) ([][]byte, *ListPage, error) {
 
-func ReplaceSkuTransactionObj(stub shim.ChaincodeStubInterface, tableName string, ar SkuTransactionObj) pb.Response {
+	args, includeDeleted := IncludeDeletedArg(args)
+	if len(args) < 1 || len(args) > 3 {
+		return nil, nil, errors.New("Incorrect number of arguments. Expecting TraceCode [, pageSize [, bookmark]] [, includeDeleted]")
+	}
+	if len(args) == 1 {
+		values, tombstones, err := GetListValuesWithTombstones(stub, objectType, args[0:1])
+		if err != nil || !includeDeleted {
+			return values, nil, err
+		}
+		return values, &ListPage{Tombstones: RawMessages(tombstones)}, nil
+	}
 
-	buff, err := SkuTransactionToJSON(ar)
+	pageSize, err := strconv.Atoi(args[1])
 	if err != nil {
-		fmt.Println("ReplaceSkuTransactionObj() : Failed Cannot create object buffer for write : ", ar.TraceCode)
-		return shim.Error("ReplaceSkuTransactionObj(): Failed Cannot create object buffer for write : " + ar.TraceCode)
+		return nil, nil, errors.New("pageSize must be a number : " + args[1])
 	}
-	// Update the ledger with the Buffer Data
-	keys := []string{ar.TraceCode}
-	err = ReplaceObject(stub, tableName, keys, buff)
+	bookmark := ""
+	if len(args) == 3 {
+		bookmark = args[2]
+	}
+	values, tombstones, next, err := GetListPageWithTombstones(stub, objectType, args[0:1], pageSize, bookmark)
 	if err != nil {
-		fmt.Println("ReplaceSkuTransactionObj() : write error while inserting record")
-		return shim.Error(err.Error())
+		return nil, nil, err
 	}
-	return shim.Success(buff)
+	page := &ListPage{Bookmark: next}
+	if includeDeleted {
+		page.Tombstones = RawMessages(tombstones)
+	}
+	return values, page, nil
 }
 
+func RawMessages(values [][]byte) []json.RawMessage {
 
-func UpdateSkuTraceRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-
-	return shim.Success(nil)
+	messages := make([]json.RawMessage, len(values))
+	for i, value := range values {
+		messages[i] = value
+	}
+	return messages
 }
 
-func UpdateSkuAuthenticationTraceRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+//////////////////////////////////////////////////////////
+// Marshal the result of a list query, as a page if one
+// was requested
+//////////////////////////////////////////////////////////
+func MarshalList(tlist interface{}, count int, page *ListPage) ([]byte, error) {
 
-	return shim.Success(nil)
+	if page == nil {
+		return json.Marshal(tlist)
+	}
+	page.Records = tlist
+	page.RecordCount = count
+	return json.Marshal(page)
 }
 
 //////////////////////////////////////////////////////////
@@ -1148,53 +1078,29 @@ func JSONtoSkuAuthenticationTraceRecordObj(args []byte) (SkuAuthenticationTraceR
 	}
 	return ar, err
 }
-/////////////////////////////////////////////////////////////////////////////////////////////////////
-// Get List of SKU authentication record for an TraceCode
-// in the block-chain --
-// peer chaincode query -l golang -n test_trace -c '{"Function": "qGetSkuAuthenticationRecordListByTraceCode", "Args": ["1111"]}' -o orderer0:7050
-/////////////////////////////////////////////////////////////////////////////////////////////////////
-func GetSkuAuthenticationRecordListByTraceCode(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
-	}
-	rs, err := GetList(stub, "SkuAuthenticationTraceRecordObj", args)
-	if err != nil {
-		error_str := fmt.Sprintf("GetSkuAuthenticationRecordListByTraceCode operation failed. Error marshaling JSON: %s", err)
-		return shim.Error(error_str)
-	}
-
-	defer rs.Close()
-
-	// Iterate through result set
-	var i int
-	var tlist []SkuAuthenticationTraceRecordObj // Define a list
-	for i = 0; rs.HasNext(); i++ {
+//////////////////////////////////////////////////////////
+// A SkuAuthenticationTraceRecordObj as it is listed, with
+// its status at the time of the query
+//////////////////////////////////////////////////////////
+type SkuAuthenticationTraceRecordView struct {
+	SkuAuthenticationTraceRecordObj
+	Status         string // VALID, NOT_YET_VALID, EXPIRED, REVOKED or SUSPENDED
+}
 
-		// We can process whichever return value is of interest
-		_, value, err := rs.Next()
-		if err != nil {
-			return shim.Success(nil)
-		}
-		bid, err := JSONtoSkuAuthenticationTraceRecordObj(value)
-		if err != nil {
-			error_str := fmt.Sprintf("GetSkuAuthenticationRecordListByTraceCode() operation failed - Unmarshall Error. %s", err)
-			fmt.Println(error_str)
-			return shim.Error(error_str)
-		}
-		fmt.Println("GetList() : my Value : ", bid)
-		tlist = append(tlist, bid)
-	}
+//////////////////////////////////////////////////////////
+// Lists SkuAuthenticationTraceRecordObj with their status
+//////////////////////////////////////////////////////////
+func SkuAuthenticationListView(stub shim.ChaincodeStubInterface, objects []interface{}) (interface{}, error) {
 
-	jsonRows, err := json.Marshal(tlist)
+	now, err := GetTxTime(stub)
 	if err != nil {
-		error_str := fmt.Sprintf("GetSkuAuthenticationRecordListByTraceCode() operation failed - Unmarshall Error. %s", err)
-		fmt.Println(error_str)
-		return shim.Error(error_str)
+		return nil, err
 	}
-
-	fmt.Println("List of SkuAuthenticationTraceRecordObj Requested : ", jsonRows)
-	return shim.Success(jsonRows)
-
+	records := make([]SkuAuthenticationTraceRecordObj, len(objects))
+	for i, object := range objects {
+		records[i] = *object.(*SkuAuthenticationTraceRecordObj)
+	}
+	return NewCertificationBodies(stub).SkuAuthenticationViews(records, now)
 }
 
 //////////////////////////////////////////////////////////
@@ -1210,99 +1116,3 @@ func JSONtoSkuTraceRecordObj(areq []byte) (SkuTraceRecordObj, error) {
 	}
 	return ar, err
 }
-/////////////////////////////////////////////////////////////////////////////////////////////////////
-// Get List of SKU trace record for an TraceCode
-// in the block-chain --
-// peer chaincode query -l golang -n test_trace -c '{"Function": "qGetSkuTraceRecordListByTraceCode", "Args": ["1111"]}' -o orderer0:7050
-/////////////////////////////////////////////////////////////////////////////////////////////////////
-func GetSkuTraceRecordListByTraceCode(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
-	}
-	rs, err := GetList(stub, "SkuTraceRecordObj", args)
-	if err != nil {
-		error_str := fmt.Sprintf("GetSkuTraceRecordListByTraceCode operation failed. Error marshaling JSON: %s", err)
-		return shim.Error(error_str)
-	}
-
-	defer rs.Close()
-
-	// Iterate through result set
-	var i int
-	var tlist []SkuTraceRecordObj // Define a list
-	for i = 0; rs.HasNext(); i++ {
-
-		// We can process whichever return value is of interest
-		_, value, err := rs.Next()
-		if err != nil {
-			return shim.Success(nil)
-		}
-		bid, err := JSONtoSkuTraceRecordObj(value)
-		if err != nil {
-			error_str := fmt.Sprintf("GetSkuTraceRecordListByTraceCode() operation failed - Unmarshall Error. %s", err)
-			fmt.Println(error_str)
-			return shim.Error(error_str)
-		}
-		fmt.Println("GetSkuTraceRecordListByTraceCode() : my Value : ", bid)
-		tlist = append(tlist, bid)
-	}
-
-	jsonRows, err := json.Marshal(tlist)
-	if err != nil {
-		error_str := fmt.Sprintf("GetSkuTraceRecordListByTraceCode() operation failed - Unmarshall Error. %s", err)
-		fmt.Println(error_str)
-		return shim.Error(error_str)
-	}
-
-	fmt.Println("List of SkuTraceRecordObj Requested : ", jsonRows)
-	return shim.Success(jsonRows)
-
-}
-
-/////////////////////////////////////////////////////////////////////////////////////////////////////
-// Get List of SKU trade record for an TraceCode
-// in the block-chain --
-// peer chaincode query -l golang -n test_trace -c '{"Function": "qGetSkuTransactionListByTraceCode", "Args": ["1111"]}' -o orderer0:7050
-/////////////////////////////////////////////////////////////////////////////////////////////////////
-func GetSkuTransactionListByTraceCode(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	if len(args) != 1 {
-		return shim.Error("Incorrect number of arguments. Expecting 1")
-	}
-	rs, err := GetList(stub, "SkuTransactionObj", args)
-	if err != nil {
-		error_str := fmt.Sprintf("GetSkuTransactionListByTraceCode operation failed. Error marshaling JSON: %s", err)
-		return shim.Error(error_str)
-	}
-
-	defer rs.Close()
-
-	// Iterate through result set
-	var i int
-	var tlist []SkuTransactionObj // Define a list
-	for i = 0; rs.HasNext(); i++ {
-
-		// We can process whichever return value is of interest
-		_, value, err := rs.Next()
-		if err != nil {
-			return shim.Success(nil)
-		}
-		bid, err := JSONtoSkuTransactionObj(value)
-		if err != nil {
-			error_str := fmt.Sprintf("GetSkuTransactionListByTraceCode() operation failed - Unmarshall Error. %s", err)
-			fmt.Println(error_str)
-			return shim.Error(error_str)
-		}
-		fmt.Println("GetSkuTransactionListByTraceCode() : my Value : ", bid)
-		tlist = append(tlist, bid)
-	}
-
-	jsonRows, err := json.Marshal(tlist)
-	if err != nil {
-		error_str := fmt.Sprintf("GetSkuTransactionListByTraceCode() operation failed - Unmarshall Error. %s", err)
-		fmt.Println(error_str)
-		return shim.Error(error_str)
-	}
-
-	fmt.Println("List of SkuTransactionObj Requested : ", jsonRows)
-	return shim.Success(jsonRows)
-}
\ No newline at end of file
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func testTransaction(orderId string, transType string, num string) SkuTransactionObj {
+	return SkuTransactionObj{OrderId: orderId, SkuId: "sku1", TraceCode: "tc1", TransType: transType, BatchNum: "b1",
+		AccountNo: "a1", Num: num, TransDate: "2017-05-01 00:00:00"}
+}
+
+func TestSkuTransactionRevision(t *testing.T) {
+
+	stub := newTestStub(t)
+	stub.mustTransact(t, "iPostSkuTransaction", toJSON(t, testTransaction("o1", "Commission", "1")))
+
+	keys := []string{"tc1", "sku1", "o1", "Commission"}
+	tests := []struct {
+		patch    string
+		status   int32
+		revision string // stored after the update
+	}{
+		{`{"Num":"2","Revision":"1"}`, shim.OK, "2"},
+		{`{"Num":"3","Revision":"1"}`, CONFLICT, "2"},
+		{`{"Num":"3"}`, shim.ERROR, "2"},
+		{`{"Num":"3","Revision":"x"}`, shim.ERROR, "2"},
+		{`{"Num":"3","Revision":"3"}`, CONFLICT, "2"},
+		{`{"Num":"3","Revision":"2"}`, shim.OK, "3"},
+		{`{"OrderId":"o2","Revision":"3"}`, shim.ERROR, "3"},
+	}
+
+	for _, test := range tests {
+		r := stub.transact("iUpdateSkuTransaction", append(keys, test.patch)...)
+		if r.Status != test.status {
+			t.Errorf("patch %s : status %d, want %d : %s", test.patch, r.Status, test.status, r.Message)
+		}
+		var stored SkuTransactionObj
+		json.Unmarshal(stub.object(t, "SkuTransactionObj", keys...), &stored)
+		if stored.Revision != test.revision {
+			t.Errorf("patch %s : revision %s, want %s", test.patch, stored.Revision, test.revision)
+		}
+	}
+
+	// A posted transaction is new, a stored one is only changed by an update
+	r := stub.transact("iPostSkuTransaction", toJSON(t, testTransaction("o1", "Commission", "5")))
+	if r.Status != CONFLICT {
+		t.Errorf("posting a stored transaction : status %d, want %d : %s", r.Status, CONFLICT, r.Message)
+	}
+}
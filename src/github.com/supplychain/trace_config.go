@@ -0,0 +1,522 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"strconv"
+)
+
+///////////////////////////////////////////////////////////////////////////////////////
+//
+// Chaincode configuration
+//
+// A single ChaincodeConfigObj is kept on the ledger of each channel
+//     Admins             : the identities that may change the configuration, any identity
+//                          of the MSP when CommonName is empty
+//     EnabledObjectTypes : the Object types that may be written, all of them when empty.
+//                          The types the chaincode keeps for itself are always enabled, and
+//                          the ones kept along with another type are enabled with it
+//     Switches           : values of the channel settings, see trace_settings.go, used
+//                          while a setting was not changed with iSetChannelSetting
+//     SchemaVersion      : the layout of the ledger, set by the chaincode
+//     MigrationBookmark  : where the migration to the next SchemaVersion stopped, set
+//                          by the chaincode
+//
+// Init stores the configuration given at instantiation, and an upgrade keeps the one
+// on the ledger unless it names no Admins yet. Either way Init migrates the ledger
+// towards CHAINCODE_SCHEMA_VERSION, visiting at most MIGRATION_BATCH_SIZE records.
+// A migration Init leaves unfinished is resumed by an admin with iMigrateSchema, and
+// until it finishes the records can not be written
+// peer chaincode instantiate -v 1.0 -n test_trace -p ... -c '{"Args":["init","{\"Admins\":[{\"MspId\":\"AdminMSP\"}],
+// \"EnabledObjectTypes\":[],\"Switches\":{\"StationContinuity\":\"reject\"}}"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////
+
+type ChaincodeAdmin struct {
+	MspId      string
+	CommonName string
+}
+
+type ChaincodeConfigObj struct {
+	Admins             []ChaincodeAdmin
+	EnabledObjectTypes []string
+	Switches           map[string]string
+	SchemaVersion      int
+	MigrationBookmark  string `json:",omitempty"`
+	TimeStamp          string // This is the time stamp
+}
+
+// The ChaincodeConfigObj is a single record kept under this key
+const CHAINCODE_CONFIG_KEY = "ChaincodeConfig"
+
+//////////////////////////////////////////////////////////
+// Layout of the ledger this chaincode reads and writes.
+// SchemaMigrations[v] brings a ledger from version v to
+// v+1, version 0 being a ledger written before the
+// configuration was kept
+//////////////////////////////////////////////////////////
+const CHAINCODE_SCHEMA_VERSION = 3
+
+var SchemaMigrations = []SchemaMigration{
+	IndexAllObjects,
+	RekeySkuBaseInfo,
+	RekeyTraceCodeLinks,
+}
+
+//////////////////////////////////////////////////////////
+// A migration visits the records from the composite key
+// bookmark on, at most batchSize of them, see
+// VisitObjects. It returns the key to resume from, empty
+// once it is done, and the number of records visited
+//////////////////////////////////////////////////////////
+type SchemaMigration func(stub shim.ChaincodeStubInterface, bookmark string, batchSize int) (string, int, error)
+
+// Records a migration visits in one transaction, unless iMigrateSchema is given another number
+const MIGRATION_BATCH_SIZE = 500
+
+var (
+	ErrObjectTypeDisabled = errors.New("OBJECT_TYPE_DISABLED")
+	ErrMigrationPending   = errors.New("MIGRATION_PENDING")
+)
+
+//////////////////////////////////////////////////////////
+// Load the ChaincodeConfigObj, nil if none is set
+//////////////////////////////////////////////////////////
+func GetChaincodeConfig(stub shim.ChaincodeStubInterface) (*ChaincodeConfigObj, error) {
+
+	Avalbytes, err := QueryObject(stub, "ChaincodeConfigObj", []string{CHAINCODE_CONFIG_KEY})
+	if err != nil {
+		return nil, err
+	}
+	if Avalbytes == nil {
+		return nil, nil
+	}
+	config := &ChaincodeConfigObj{}
+	err = json.Unmarshal(Avalbytes, config)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+//////////////////////////////////////////////////////////
+// Decode and check a configuration given by a caller
+//////////////////////////////////////////////////////////
+func JSONtoChaincodeConfigObj(objectData []byte) (ChaincodeConfigObj, error) {
+
+	var config ChaincodeConfigObj
+	err := JSONtoObject("ChaincodeConfigObj", objectData, &config, nil)
+	if err != nil {
+		return config, err
+	}
+	for i, admin := range config.Admins {
+		if admin.MspId == "" {
+			return config, fmt.Errorf("admin %d must name an MspId", i)
+		}
+	}
+	for _, name := range config.EnabledObjectTypes {
+		if GetObjectType(name) == nil {
+			return config, errors.New("unknown Object type " + name)
+		}
+	}
+	for name, value := range config.Switches {
+		setting, ok := ChannelSettings[name]
+		if !ok {
+			return config, errors.New("unknown channel setting " + name)
+		}
+		err = setting.Validate(value)
+		if err != nil {
+			return config, errors.New(name + " : " + err.Error())
+		}
+	}
+	return config, nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Check the submitter is an admin of the configuration on the ledger,
+// which is returned
+////////////////////////////////////////////////////////////////////////////
+func AuthorizeAdmin(stub shim.ChaincodeStubInterface) (*ChaincodeConfigObj, error) {
+
+	stored, err := GetChaincodeConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil || len(stored.Admins) == 0 {
+		return nil, fmt.Errorf("%s : no admins are configured, name them at Init", ErrAccessDenied)
+	}
+	caller, err := GetCallerIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+	if !stored.isAdmin(caller) {
+		return nil, fmt.Errorf("%s : %s is not an admin", ErrAccessDenied, caller)
+	}
+	return stored, nil
+}
+
+func (c *ChaincodeConfigObj) isAdmin(caller CallerIdentity) bool {
+	for _, admin := range c.Admins {
+		if admin.MspId == caller.MspId && (admin.CommonName == "" || admin.CommonName == caller.CommonName) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ChaincodeConfigObj) switchValue(name string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	value, ok := c.Switches[name]
+	return value, ok
+}
+
+func (c *ChaincodeConfigObj) enables(objectType string) bool {
+	if len(c.EnabledObjectTypes) == 0 {
+		return true
+	}
+	for _, name := range c.EnabledObjectTypes {
+		if name == objectType {
+			return true
+		}
+	}
+	return false
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Check Objects of objectType may be written on this channel, and that the
+// ledger is not halfway through a migration
+////////////////////////////////////////////////////////////////////////////
+func CheckObjectTypeEnabled(stub shim.ChaincodeStubInterface, objectType string) error {
+
+	registered := GetObjectType(objectType)
+	if registered == nil || registered.System {
+		return nil
+	}
+	config, err := GetChaincodeConfig(stub)
+	if err != nil || config == nil {
+		return err
+	}
+	if config.SchemaVersion < CHAINCODE_SCHEMA_VERSION {
+		return fmt.Errorf("%s : the ledger is being migrated to schema version %d, resume it with iMigrateSchema", ErrMigrationPending, config.SchemaVersion+1)
+	}
+	if registered.EnabledWith != "" {
+		objectType = registered.EnabledWith
+	}
+	if !config.enables(objectType) {
+		return fmt.Errorf("%s : %s is not enabled on this channel", ErrObjectTypeDisabled, objectType)
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Store the configuration given to Init, nil for none, and migrate a batch
+// of the ledger towards CHAINCODE_SCHEMA_VERSION. Returns the configuration
+// stored, nil when there was nothing to change
+////////////////////////////////////////////////////////////////////////////
+func InitChaincodeConfig(stub shim.ChaincodeStubInterface, objectData []byte) ([]byte, error) {
+
+	stored, err := GetChaincodeConfig(stub)
+	if err != nil {
+		return nil, err
+	}
+	from, bookmark := 0, ""
+	if stored != nil {
+		from, bookmark = stored.SchemaVersion, stored.MigrationBookmark
+	}
+	if from > CHAINCODE_SCHEMA_VERSION {
+		return nil, fmt.Errorf("InitChaincodeConfig() : the ledger is at schema version %d, this chaincode knows up to %d", from, CHAINCODE_SCHEMA_VERSION)
+	}
+
+	config := stored
+	if objectData != nil {
+		given, err := JSONtoChaincodeConfigObj(objectData)
+		if err != nil {
+			return nil, err
+		}
+		if stored == nil || len(stored.Admins) == 0 {
+			config = &given
+		} else {
+			fmt.Println("InitChaincodeConfig() : Keeping the configuration on the ledger, change it with iSetChaincodeConfig")
+		}
+	}
+	if config == nil {
+		config = &ChaincodeConfigObj{}
+	}
+	if config == stored && from == CHAINCODE_SCHEMA_VERSION {
+		return nil, nil
+	}
+
+	config.SchemaVersion, config.MigrationBookmark = from, bookmark
+	err = MigrateSchema(stub, config, MIGRATION_BATCH_SIZE)
+	if err != nil {
+		return nil, err
+	}
+	return StoreChaincodeConfig(stub, *config)
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Run the migrations of config from its SchemaVersion and MigrationBookmark
+// on, visiting at most batchSize records, and keep in config how far they got
+////////////////////////////////////////////////////////////////////////////
+func MigrateSchema(stub shim.ChaincodeStubInterface, config *ChaincodeConfigObj, batchSize int) error {
+
+	for config.SchemaVersion < CHAINCODE_SCHEMA_VERSION {
+		bookmark, err := base64.StdEncoding.DecodeString(config.MigrationBookmark)
+		if err != nil {
+			return errors.New("MigrateSchema() : MigrationBookmark is not valid : " + config.MigrationBookmark)
+		}
+		fmt.Println("MigrateSchema() : Migrating the ledger from schema version ", config.SchemaVersion)
+		next, visited, err := SchemaMigrations[config.SchemaVersion](stub, string(bookmark), batchSize)
+		if err != nil {
+			return fmt.Errorf("MigrateSchema() : migration from schema version %d failed : %s", config.SchemaVersion, err)
+		}
+		if next != "" {
+			config.MigrationBookmark = base64.StdEncoding.EncodeToString([]byte(next))
+			return nil
+		}
+		config.SchemaVersion++
+		config.MigrationBookmark = ""
+		batchSize -= visited
+	}
+	return nil
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Resume the migration of the ledger Init left unfinished, only an admin may. Returns the ChaincodeConfigObj,
+// call again while its SchemaVersion is below CHAINCODE_SCHEMA_VERSION
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iMigrateSchema", "Args":["batchSize"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func MigrateSchemaRecords(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) > 1 {
+		return shim.Error("MigrateSchemaRecords(): Incorrect number of arguments. Expecting [batchSize]")
+	}
+	batchSize := MIGRATION_BATCH_SIZE
+	if len(args) == 1 {
+		size, err := strconv.Atoi(args[0])
+		if err != nil || size < 1 || size > MAX_PAGE_SIZE {
+			return shim.Error(fmt.Sprintf("MigrateSchemaRecords(): batchSize must be between 1 and %d : %s", MAX_PAGE_SIZE, args[0]))
+		}
+		batchSize = size
+	}
+	stored, err := AuthorizeAdmin(stub)
+	if err != nil {
+		return shim.Error("MigrateSchemaRecords(): " + err.Error())
+	}
+	if stored.SchemaVersion >= CHAINCODE_SCHEMA_VERSION {
+		return shim.Error(fmt.Sprintf("MigrateSchemaRecords(): the ledger is already at schema version %d", stored.SchemaVersion))
+	}
+
+	err = MigrateSchema(stub, stored, batchSize)
+	if err != nil {
+		return shim.Error("MigrateSchemaRecords(): " + err.Error())
+	}
+	buff, err := StoreChaincodeConfig(stub, *stored)
+	if err != nil {
+		fmt.Println("MigrateSchemaRecords() : write error while inserting record")
+		return shim.Error("MigrateSchemaRecords(): " + err.Error())
+	}
+	err = EmitObjectEvent(stub, EVENT_CHAINCODE_CONFIG_SET, "ChaincodeConfigObj", []string{CHAINCODE_CONFIG_KEY}, buff)
+	if err != nil {
+		return shim.Error("MigrateSchemaRecords(): " + err.Error())
+	}
+	return shim.Success(buff)
+}
+
+//////////////////////////////////////////////////////////
+// Write the ChaincodeConfigObj, the caller emits the event
+//////////////////////////////////////////////////////////
+func StoreChaincodeConfig(stub shim.ChaincodeStubInterface, config ChaincodeConfigObj) ([]byte, error) {
+
+	now, err := GetTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	config.TimeStamp = now.Format(TimeLayout)
+
+	buff, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	err = ReplaceObject(stub, "ChaincodeConfigObj", []string{CHAINCODE_CONFIG_KEY}, buff)
+	if err != nil {
+		return nil, err
+	}
+	return buff, nil
+}
+
+//////////////////////////////////////////////////////////
+// Migration to schema version 1: index the Objects
+// written before their indexes were declared
+//////////////////////////////////////////////////////////
+func IndexAllObjects(stub shim.ChaincodeStubInterface, bookmark string, batchSize int) (string, int, error) {
+
+	var indexed []string
+	for _, name := range ObjectTypeNames() {
+		if len(ObjectIndexes(name)) > 0 {
+			indexed = append(indexed, name)
+		}
+	}
+	return VisitObjects(stub, indexed, bookmark, batchSize, func(objectType string, compositeKey string, keys []string, objectData []byte) error {
+		return IndexObject(stub, objectType, keys, objectData)
+	})
+}
+
+//////////////////////////////////////////////////////////
+// Migration to schema version 2: move the SkuBaseInfoObj
+// stored under their SkuId to their TraceCode, the key
+// they are looked up by. A record may move to the key
+// another one leaves, which then moves first. A record
+// without a TraceCode, or whose TraceCode another record
+// holds, is left where it is
+//////////////////////////////////////////////////////////
+func RekeySkuBaseInfo(stub shim.ChaincodeStubInterface, bookmark string, batchSize int) (string, int, error) {
+
+	type move struct {
+		key   string
+		rec   SkuBaseInfoObj
+		value []byte
+	}
+	var moves []move
+	// Writes are not visible to reads within the same transaction, the
+	// keys left and the TraceCodes taken in this one are tracked here
+	leaves := map[string]bool{}
+	taken := map[string]bool{}
+
+	// Move the record under key, after the one holding its TraceCode if that
+	// one moves too. The chain followed is at most batchSize records long
+	var moveRecord func(key string, value []byte, depth int) (bool, error)
+	moveRecord = func(key string, value []byte, depth int) (bool, error) {
+
+		if left, ok := leaves[key]; ok {
+			return left, nil
+		}
+		rec, err := JSONtoSkuBaseInfoObj(value)
+		if err != nil {
+			return false, err
+		}
+		if rec.TraceCode == key || rec.TraceCode == "" || taken[rec.TraceCode] || depth > batchSize {
+			return false, nil
+		}
+		// Taken while the holder is tried, a chain coming back here finds the key left
+		leaves[key], taken[rec.TraceCode] = true, true
+
+		held, err := QueryObject(stub, "SkuBaseInfoObj", []string{rec.TraceCode})
+		if err != nil {
+			return false, err
+		}
+		if held != nil {
+			left, err := moveRecord(rec.TraceCode, held, depth+1)
+			if err != nil {
+				return false, err
+			}
+			if !left {
+				fmt.Println("RekeySkuBaseInfo() : Leaving SkuBaseInfoObj ", key, " under its SkuId, its TraceCode is taken : ", rec.TraceCode)
+				leaves[key], taken[rec.TraceCode] = false, false
+				return false, nil
+			}
+		}
+		moves = append(moves, move{key, rec, value})
+		return true, nil
+	}
+
+	next, visited, err := VisitObjects(stub, []string{"SkuBaseInfoObj"}, bookmark, batchSize, func(objectType string, compositeKey string, keys []string, objectData []byte) error {
+		if IsTombstone(objectData) {
+			return nil
+		}
+		_, err := moveRecord(keys[0], objectData, 0)
+		return err
+	})
+	if err != nil {
+		return "", visited, err
+	}
+
+	// Every record leaves its old key before any is written
+	for _, m := range moves {
+		stale, err := IndexEntries(stub, "SkuBaseInfoObj", []string{m.key}, m.value)
+		if err != nil {
+			return "", visited, err
+		}
+		for entry := range stale {
+			err = stub.DelState(entry)
+			if err != nil {
+				return "", visited, err
+			}
+		}
+		oldKey, _ := stub.CreateCompositeKey("SkuBaseInfoObj", []string{m.key})
+		err = stub.DelState(oldKey)
+		if err != nil {
+			return "", visited, err
+		}
+	}
+	for _, m := range moves {
+		keys := []string{m.rec.TraceCode}
+		newKey, _ := stub.CreateCompositeKey("SkuBaseInfoObj", keys)
+		err = stub.PutState(newKey, m.value)
+		if err != nil {
+			return "", visited, err
+		}
+		err = IndexObject(stub, "SkuBaseInfoObj", keys, m.value)
+		if err != nil {
+			return "", visited, err
+		}
+	}
+
+	fmt.Println("RekeySkuBaseInfo() : Moved ", len(moves), " SkuBaseInfoObj under their TraceCode")
+	return next, visited, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Replace the ChaincodeConfigObj, only an admin of the configuration on the ledger may.
+// The SchemaVersion and MigrationBookmark stay the ones of the ledger
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iSetChaincodeConfig", "Args":["{\"Admins\":[...],
+// \"EnabledObjectTypes\":[...],\"Switches\":{...}}"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func SetChaincodeConfig(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 1 {
+		return shim.Error("SetChaincodeConfig(): Incorrect number of arguments. Expecting 1")
+	}
+	stored, err := AuthorizeAdmin(stub)
+	if err != nil {
+		return shim.Error("SetChaincodeConfig(): " + err.Error())
+	}
+
+	config, err := JSONtoChaincodeConfigObj([]byte(args[0]))
+	if err != nil {
+		return shim.Error("SetChaincodeConfig(): " + err.Error())
+	}
+	if len(config.Admins) == 0 {
+		return shim.Error("SetChaincodeConfig(): the configuration must keep at least one admin")
+	}
+	config.SchemaVersion, config.MigrationBookmark = stored.SchemaVersion, stored.MigrationBookmark
+
+	buff, err := StoreChaincodeConfig(stub, config)
+	if err != nil {
+		fmt.Println("SetChaincodeConfig() : write error while inserting record")
+		return shim.Error("SetChaincodeConfig(): " + err.Error())
+	}
+	err = EmitObjectEvent(stub, EVENT_CHAINCODE_CONFIG_SET, "ChaincodeConfigObj", []string{CHAINCODE_CONFIG_KEY}, buff)
+	if err != nil {
+		return shim.Error("SetChaincodeConfig(): " + err.Error())
+	}
+	return shim.Success(buff)
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+// Retrieve the ChaincodeConfigObj
+// peer chaincode query -n test_trace -c '{"Function": "qGetChaincodeConfig","Args":[]}' -o orderer0:7050
+//////////////////////////////////////////////////////////////////////////////////////////
+func GetChaincodeConfigInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	Avalbytes, err := QueryObject(stub, "ChaincodeConfigObj", []string{CHAINCODE_CONFIG_KEY})
+	if err != nil {
+		return shim.Error("GetChaincodeConfigInfo(): " + err.Error())
+	}
+	return shim.Success(Avalbytes)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+///////////////////////////////////////////////////////////////////////////////////////
+//
+// Station continuity
+//
+// A new SkuTraceRecordObj must come from where the TraceCode was before: its PreStation
+// is the NextStation or the StationName of the record before it, the latest record of
+// that TraceCode on the ledger whose BeginTime is not after its own. A record posted
+// late is so checked against the one it follows rather than against the latest of all,
+// and the first record of a TraceCode is not checked. An update that keeps the
+// PreStation, StationName and BeginTime of the stored record keeps its Discontinuity.
+//
+// What happens to a record that does not follow depends on the StationContinuity
+// channel setting
+//     flag   : the record is stored with Discontinuity set (default)
+//     reject : the record is rejected
+///////////////////////////////////////////////////////////////////////////////////////
+
+const SETTING_STATION_CONTINUITY = "StationContinuity"
+
+const (
+	CONTINUITY_FLAG   = "flag"
+	CONTINUITY_REJECT = "reject"
+)
+
+//////////////////////////////////////////////////////////
+// Checks the records posted in one transaction. Reads do
+// not see the writes of the transaction, so the records
+// checked are remembered along with the ledger ones
+//////////////////////////////////////////////////////////
+type StationContinuityChecker struct {
+	stub    shim.ChaincodeStubInterface
+	mode    string
+	records map[string][]SkuTraceRecordObj // by TraceCode
+}
+
+func NewStationContinuityChecker(stub shim.ChaincodeStubInterface) (*StationContinuityChecker, error) {
+
+	mode, err := GetChannelSetting(stub, SETTING_STATION_CONTINUITY)
+	if err != nil {
+		return nil, err
+	}
+	return &StationContinuityChecker{stub, mode, map[string][]SkuTraceRecordObj{}}, nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Check that record follows the record before it in its TraceCode
+// Sets record.Discontinuity, or fails when the channel rejects
+// discontinuities
+////////////////////////////////////////////////////////////////////////////
+func (c *StationContinuityChecker) Check(record *SkuTraceRecordObj) error {
+
+	records, ok := c.records[record.TraceCode]
+	if !ok {
+		var err error
+		records, err = LoadSkuTraceRecords(c.stub, record.TraceCode)
+		if err != nil {
+			return err
+		}
+	}
+
+	record.Discontinuity = false
+	before := PreviousSkuTraceRecord(records, *record)
+	if before != nil && !ContinuesStation(*before, *record) {
+		if c.mode == CONTINUITY_REJECT {
+			return fmt.Errorf("STATION_DISCONTINUITY : PreStation %q does not follow %s (NextStation %q)", record.PreStation, before.StationName, before.NextStation)
+		}
+		fmt.Println("StationContinuityChecker.Check() : flagging discontinuity after ", before.StationName)
+		record.Discontinuity = true
+	}
+
+	// The record replaces any one with the same key
+	for i := range records {
+		if SameSkuTraceRecordKey(records[i], *record) {
+			records = append(records[:i], records[i+1:]...)
+			break
+		}
+	}
+	c.records[record.TraceCode] = append(records, *record)
+	return nil
+}
+
+//////////////////////////////////////////////////////////
+// The record with the latest BeginTime up to the one of
+// record, other than record itself, nil if there is none
+//////////////////////////////////////////////////////////
+func PreviousSkuTraceRecord(records []SkuTraceRecordObj, record SkuTraceRecordObj) *SkuTraceRecordObj {
+
+	var before *SkuTraceRecordObj
+	for i := range records {
+		if SameSkuTraceRecordKey(records[i], record) || records[i].BeginTime > record.BeginTime {
+			continue
+		}
+		if before == nil || records[i].BeginTime >= before.BeginTime {
+			before = &records[i]
+		}
+	}
+	return before
+}
+
+//////////////////////////////////////////////////////////
+// Whether record comes from the station of before
+//////////////////////////////////////////////////////////
+func ContinuesStation(before SkuTraceRecordObj, record SkuTraceRecordObj) bool {
+
+	if record.PreStation == "" {
+		return false
+	}
+	return record.PreStation == before.NextStation || record.PreStation == before.StationName
+}
+
+//////////////////////////////////////////////////////////
+// Whether an update leaves where stored was, and when,
+// as it is
+//////////////////////////////////////////////////////////
+func SameStation(stored SkuTraceRecordObj, record SkuTraceRecordObj) bool {
+	return stored.PreStation == record.PreStation && stored.StationName == record.StationName && stored.BeginTime == record.BeginTime
+}
+
+func SameSkuTraceRecordKey(a SkuTraceRecordObj, b SkuTraceRecordObj) bool {
+	return a.TraceCode == b.TraceCode && a.SkuId == b.SkuId && a.AddressHash == b.AddressHash && a.StationType == b.StationType
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestStationContinuity(t *testing.T) {
+
+	farm := newTestSigner(t, "farm")
+	record := func(stationType string, preStation string, beginTime string) SkuTraceRecordObj {
+		rec := farm.traceRecord("tc1", stationType, preStation)
+		rec.BeginTime = beginTime
+		rec.Signature = farm.sign(SkuTraceRecordSigningBytes(rec))
+		return rec
+	}
+	factory := record("Factory", "", "2017-05-01 00:00:00")
+	warehouse := record("Warehouse", "Factory", "2017-05-03 00:00:00")
+	truck := record("Truck", "Factory", "2017-05-02 00:00:00")
+	customs := record("Customs", "Port", "2017-05-02 12:00:00")
+
+	// The patch of rec setting attribute to value, signed again
+	patch := func(rec SkuTraceRecordObj, attribute string, value string) []string {
+		fields := map[string]string{attribute: value}
+		buff, _ := json.Marshal(fields)
+		json.Unmarshal(buff, &rec)
+		fields["Signature"] = farm.sign(SkuTraceRecordSigningBytes(rec))
+		return []string{rec.TraceCode, rec.SkuId, rec.AddressHash, rec.StationType, toJSON(t, fields)}
+	}
+
+	tests := []struct {
+		name          string
+		function      string
+		args          []string
+		stationType   string // of the record written
+		discontinuity bool   // a reject channel refuses the record
+	}{
+		{"first record", "iPostSkuTraceRecord", []string{toJSON(t, factory)}, "Factory", false},
+		{"next record", "iPostSkuTraceRecord", []string{toJSON(t, warehouse)}, "Warehouse", false},
+		{"earlier record patched", "iUpdateSkuTraceRecord", patch(factory, "ExtJsonData", `{"lot":"7"}`), "Factory", false},
+		{"backfilled record", "iPostSkuTraceRecord", []string{toJSON(t, truck)}, "Truck", false},
+		{"backfilled record from elsewhere", "iPostSkuTraceRecord", []string{toJSON(t, customs)}, "Customs", true},
+		{"record moved after a discontinuity", "iUpdateSkuTraceRecord", patch(warehouse, "BeginTime", "2017-05-02 18:00:00"), "Warehouse", true},
+	}
+
+	for _, mode := range []string{CONTINUITY_FLAG, CONTINUITY_REJECT} {
+		stub := newTestStub(t)
+		stub.mustTransact(t, "init", `{"Admins":[{"MspId":"Org1MSP"}],"Switches":{"StationContinuity":"`+mode+`"}}`)
+		stub.mustTransact(t, "iPostAccountInfo", toJSON(t, farm.account()))
+
+		for _, test := range tests {
+			before := stub.object(t, "SkuTraceRecordObj", "tc1", "sku1", "farm", test.stationType)
+			r := stub.transact(test.function, test.args...)
+			rejected := mode == CONTINUITY_REJECT && test.discontinuity
+			if (r.Status != shim.OK) != rejected {
+				t.Errorf("%s : %s : status %d, rejected %v : %s", mode, test.name, r.Status, rejected, r.Message)
+				continue
+			}
+			stored := stub.object(t, "SkuTraceRecordObj", "tc1", "sku1", "farm", test.stationType)
+			if rejected {
+				if string(stored) != string(before) {
+					t.Errorf("%s : %s : a rejected record was written", mode, test.name)
+				}
+				continue
+			}
+			var rec SkuTraceRecordObj
+			json.Unmarshal(stored, &rec)
+			if rec.Discontinuity != test.discontinuity {
+				t.Errorf("%s : %s : Discontinuity %v, want %v", mode, test.name, rec.Discontinuity, test.discontinuity)
+			}
+		}
+	}
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////////////
+//
+// Custody handoff
+//
+// The holder of a TraceCode is the AccountInfoObj in its CustodyObj, or before any
+// handoff the AddressHash of its SkuBaseInfoObj. A handoff takes two steps
+//     iProposeTransfer : the holder proposes a transfer to another account
+//     iAcceptTransfer  : the receiving account accepts, and becomes the holder
+//     iRejectTransfer  : or the receiving account rejects it
+// Each step is signed by the account taking it over the JSON array
+//     ["iProposeTransfer","TraceCode","TransferId","From","To"]
+//     ["iAcceptTransfer","TraceCode","TransferId"]
+//     ["iRejectTransfer","TraceCode","TransferId","Reason"]
+// with the key formats of trace_signature.go.
+//
+// A TraceCode has at most one PENDING transfer. A transfer not accepted within the
+// TransferTimeout channel setting (a duration such as "72h") of the transaction that
+// proposed it is EXPIRED and can no longer be accepted
+//
+// CustodyObj is the record of who holds the goods. Accepting a transfer also writes
+// the final SkuTransactionObj of the handoff, TransType Transfer, OrderId the
+// TransferId and AccountNo the receiving account. It does not change the inventory
+// and cannot be posted or updated directly. Buy and Sale record trade and do not
+// change the holder
+///////////////////////////////////////////////////////////////////////////////////////
+
+const SETTING_TRANSFER_TIMEOUT = "TransferTimeout"
+
+// TransType of the SkuTransactionObj of an accepted transfer
+const TRANS_TYPE_TRANSFER = "Transfer"
+
+var ErrReservedTransType = errors.New("RESERVED_TRANS_TYPE")
+
+const (
+	TRANSFER_PENDING  = "PENDING"
+	TRANSFER_ACCEPTED = "ACCEPTED"
+	TRANSFER_REJECTED = "REJECTED"
+	TRANSFER_EXPIRED  = "EXPIRED"
+)
+
+type TransferObj struct {
+	TransferId       string
+	TraceCode        string
+	From             string // AccountInfoObj handing the goods over
+	To               string // AccountInfoObj receiving them
+	Status           string // PENDING, ACCEPTED, REJECTED or EXPIRED
+	ProposedAt       string
+	ExpiresAt        string
+	ResolvedAt       string
+	Reason           string // Given by the receiving account when it rejects
+	ProposeSignature string
+	ResolveSignature string
+}
+
+type CustodyObj struct {
+	TraceCode         string
+	Holder            string // AccountInfoObj holding the goods
+	Since             string
+	TransferId        string // The accepted transfer that made Holder the holder
+	PendingTransferId string
+}
+
+//////////////////////////////////////////////////////////
+// Validator for a positive duration
+//////////////////////////////////////////////////////////
+func PositiveDuration(value string) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	if d <= 0 {
+		return errors.New("duration must be positive")
+	}
+	return nil
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Propose to hand a TraceCode over to another account
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iProposeTransfer", "Args":["TraceCode", "TransferId",
+// "From", "To", "Signature"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func ProposeTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 5 {
+		return shim.Error("ProposeTransfer(): Incorrect number of arguments. Expecting TraceCode, TransferId, From, To and Signature")
+	}
+	transfer := TransferObj{TraceCode: args[0], TransferId: args[1], From: args[2], To: args[3], ProposeSignature: args[4]}
+	if transfer.TraceCode == "" || transfer.TransferId == "" || transfer.From == "" || transfer.To == "" {
+		return shim.Error("ProposeTransfer(): TraceCode, TransferId, From and To are required")
+	}
+	if transfer.From == transfer.To {
+		return shim.Error("ProposeTransfer(): From and To must be different accounts")
+	}
+
+	now, err := GetTxTime(stub)
+	if err != nil {
+		return shim.Error("ProposeTransfer(): " + err.Error())
+	}
+	existing, err := GetTransfer(stub, transfer.TraceCode, transfer.TransferId)
+	if err != nil {
+		return shim.Error("ProposeTransfer(): " + err.Error())
+	}
+	if existing != nil {
+		return Conflict("ProposeTransfer(): TransferObj " + transfer.TransferId + " already exists")
+	}
+
+	custody, err := GetCustody(stub, transfer.TraceCode)
+	if err != nil {
+		return shim.Error("ProposeTransfer(): " + err.Error())
+	}
+	if custody == nil {
+		return NotFound("ProposeTransfer(): no holder for TraceCode " + transfer.TraceCode)
+	}
+	if custody.Holder != transfer.From {
+		return shim.Error("ProposeTransfer(): " + transfer.From + " does not hold " + transfer.TraceCode)
+	}
+
+	// A pending transfer blocks a new one until it expires
+	var events []ObjectEvent
+	if custody.PendingTransferId != "" {
+		pending, err := GetTransfer(stub, transfer.TraceCode, custody.PendingTransferId)
+		if err != nil {
+			return shim.Error("ProposeTransfer(): " + err.Error())
+		}
+		if pending != nil && pending.Status == TRANSFER_PENDING {
+			if TransferStatus(*pending, now) == TRANSFER_PENDING {
+				return Conflict("ProposeTransfer(): TransferObj " + pending.TransferId + " is pending")
+			}
+			pending.Status = TRANSFER_EXPIRED
+			pending.ResolvedAt = pending.ExpiresAt
+			buff, err := PutTransfer(stub, *pending)
+			if err != nil {
+				return shim.Error("ProposeTransfer(): " + err.Error())
+			}
+			events = append(events, ObjectEvent{"TransferObj", []string{pending.TraceCode, pending.TransferId}, buff})
+		}
+	}
+
+	err = VerifyTransferSignature(stub, transfer.From, now, transfer.ProposeSignature,
+		"iProposeTransfer", transfer.TraceCode, transfer.TransferId, transfer.From, transfer.To)
+	if err != nil {
+		return shim.Error("ProposeTransfer(): " + err.Error())
+	}
+	_, err = GetAccountPublicKey(stub, transfer.To, now.Format(TimeLayout))
+	if err != nil {
+		return shim.Error("ProposeTransfer(): " + err.Error())
+	}
+
+	timeout, err := GetChannelSetting(stub, SETTING_TRANSFER_TIMEOUT)
+	if err != nil {
+		return shim.Error("ProposeTransfer(): " + err.Error())
+	}
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return shim.Error("ProposeTransfer(): " + SETTING_TRANSFER_TIMEOUT + " : " + err.Error())
+	}
+	transfer.Status = TRANSFER_PENDING
+	transfer.ProposedAt = now.Format(TimeLayout)
+	transfer.ExpiresAt = now.Add(d).Format(TimeLayout)
+
+	buff, err := PutTransfer(stub, transfer)
+	if err != nil {
+		return shim.Error("ProposeTransfer(): " + err.Error())
+	}
+	custody.PendingTransferId = transfer.TransferId
+	err = PutCustody(stub, *custody)
+	if err != nil {
+		return shim.Error("ProposeTransfer(): " + err.Error())
+	}
+
+	events = append(events, ObjectEvent{"TransferObj", []string{transfer.TraceCode, transfer.TransferId}, buff})
+	err = EmitObjectBatchEvent(stub, EVENT_TRANSFER_PROPOSED, "TransferObj", events)
+	if err != nil {
+		return shim.Error("ProposeTransfer(): " + err.Error())
+	}
+	return shim.Success(buff)
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Accept a pending transfer, signed by the receiving account
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iAcceptTransfer", "Args":["TraceCode", "TransferId", "Signature"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func AcceptTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 3 {
+		return shim.Error("AcceptTransfer(): Incorrect number of arguments. Expecting TraceCode, TransferId and Signature")
+	}
+	return ResolveTransfer(stub, args[0], args[1], TRANSFER_ACCEPTED, "", args[2])
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Reject a pending transfer, signed by the receiving account
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iRejectTransfer", "Args":["TraceCode", "TransferId", "Reason", "Signature"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func RejectTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 4 {
+		return shim.Error("RejectTransfer(): Incorrect number of arguments. Expecting TraceCode, TransferId, Reason and Signature")
+	}
+	return ResolveTransfer(stub, args[0], args[1], TRANSFER_REJECTED, args[2], args[3])
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Accept or reject a pending transfer. Only an accepted transfer changes
+// the holder
+////////////////////////////////////////////////////////////////////////////
+func ResolveTransfer(stub shim.ChaincodeStubInterface, traceCode string, transferId string, status string, reason string, signature string) pb.Response {
+
+	now, err := GetTxTime(stub)
+	if err != nil {
+		return shim.Error("ResolveTransfer(): " + err.Error())
+	}
+	transfer, err := GetTransfer(stub, traceCode, transferId)
+	if err != nil {
+		return shim.Error("ResolveTransfer(): " + err.Error())
+	}
+	if transfer == nil {
+		return NotFound("ResolveTransfer(): TransferObj " + traceCode + "," + transferId)
+	}
+	current := TransferStatus(*transfer, now)
+	if current != TRANSFER_PENDING {
+		return Conflict("ResolveTransfer(): TransferObj " + transferId + " is " + current)
+	}
+
+	message := []string{"iAcceptTransfer", traceCode, transferId}
+	event := EVENT_TRANSFER_ACCEPTED
+	if status == TRANSFER_REJECTED {
+		message = []string{"iRejectTransfer", traceCode, transferId, reason}
+		event = EVENT_TRANSFER_REJECTED
+	}
+	err = VerifyTransferSignature(stub, transfer.To, now, signature, message...)
+	if err != nil {
+		return shim.Error("ResolveTransfer(): " + err.Error())
+	}
+
+	custody, err := GetCustody(stub, traceCode)
+	if err != nil {
+		return shim.Error("ResolveTransfer(): " + err.Error())
+	}
+	if custody == nil || custody.Holder != transfer.From || custody.PendingTransferId != transferId {
+		return Conflict("ResolveTransfer(): custody of " + traceCode + " changed since TransferObj " + transferId + " was proposed")
+	}
+
+	transfer.Status = status
+	transfer.ResolvedAt = now.Format(TimeLayout)
+	transfer.Reason = reason
+	transfer.ResolveSignature = signature
+	buff, err := PutTransfer(stub, *transfer)
+	if err != nil {
+		return shim.Error("ResolveTransfer(): " + err.Error())
+	}
+
+	custody.PendingTransferId = ""
+	if status == TRANSFER_ACCEPTED {
+		custody.Holder = transfer.To
+		custody.Since = transfer.ResolvedAt
+		custody.TransferId = transferId
+		err = PutTransferTransaction(stub, *transfer)
+		if err != nil {
+			return shim.Error("ResolveTransfer(): " + err.Error())
+		}
+	}
+	err = PutCustody(stub, *custody)
+	if err != nil {
+		return shim.Error("ResolveTransfer(): " + err.Error())
+	}
+
+	err = EmitObjectEvent(stub, event, "TransferObj", []string{traceCode, transferId}, buff)
+	if err != nil {
+		return shim.Error("ResolveTransfer(): " + err.Error())
+	}
+	return shim.Success(buff)
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+// Get the holder of a TraceCode
+// peer chaincode query -l golang -n test_trace -c '{"Function": "qGetCustodyByTraceCode", "Args": ["1111"]}' -o orderer0:7050
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+func GetCustodyByTraceCode(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	custody, err := GetCustody(stub, args[0])
+	if err != nil {
+		return shim.Error("GetCustodyByTraceCode() operation failed. " + err.Error())
+	}
+	if custody == nil {
+		return NotFound("GetCustodyByTraceCode(): no holder for TraceCode " + args[0])
+	}
+	buff, err := json.Marshal(custody)
+	if err != nil {
+		return shim.Error("GetCustodyByTraceCode() operation failed - Marshall Error. " + err.Error())
+	}
+	return shim.Success(buff)
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+// Get the transfers of a TraceCode, with their status at the time of the query
+// peer chaincode query -l golang -n test_trace -c '{"Function": "qGetTransferListByTraceCode", "Args": ["1111"]}' -o orderer0:7050
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+func GetTransferListByTraceCode(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	now, err := GetTxTime(stub)
+	if err != nil {
+		return shim.Error("GetTransferListByTraceCode() operation failed. " + err.Error())
+	}
+	values, err := GetListValues(stub, "TransferObj", args)
+	if err != nil {
+		return shim.Error("GetTransferListByTraceCode() operation failed. " + err.Error())
+	}
+
+	var tlist []TransferObj
+	for _, value := range values {
+		var transfer TransferObj
+		err = json.Unmarshal(value, &transfer)
+		if err != nil {
+			return shim.Error("GetTransferListByTraceCode() operation failed - Unmarshall Error. " + err.Error())
+		}
+		transfer.Status = TransferStatus(transfer, now)
+		tlist = append(tlist, transfer)
+	}
+	buff, err := json.Marshal(tlist)
+	if err != nil {
+		return shim.Error("GetTransferListByTraceCode() operation failed - Marshall Error. " + err.Error())
+	}
+	return shim.Success(buff)
+}
+
+//////////////////////////////////////////////////////////
+// The status of a transfer at time now
+//////////////////////////////////////////////////////////
+func TransferStatus(transfer TransferObj, now time.Time) string {
+
+	if transfer.Status != TRANSFER_PENDING {
+		return transfer.Status
+	}
+	if now.Format(TimeLayout) >= transfer.ExpiresAt {
+		return TRANSFER_EXPIRED
+	}
+	return TRANSFER_PENDING
+}
+
+//////////////////////////////////////////////////////////
+// Verify a handoff step signed by account
+//////////////////////////////////////////////////////////
+func VerifyTransferSignature(stub shim.ChaincodeStubInterface, account string, now time.Time, signature string, message ...string) error {
+
+	if signature == "" {
+		return &SignatureError{Err: ErrSignatureMissing, Signer: account}
+	}
+	publicKey, err := GetAccountPublicKey(stub, account, now.Format(TimeLayout))
+	if err != nil {
+		return err
+	}
+	buff, _ := json.Marshal(message)
+	return VerifySignature(account, publicKey, buff, signature)
+}
+
+//////////////////////////////////////////////////////////
+// The TransferObj, nil if there is none
+//////////////////////////////////////////////////////////
+func GetTransfer(stub shim.ChaincodeStubInterface, traceCode string, transferId string) (*TransferObj, error) {
+
+	Avalbytes, err := QueryObject(stub, "TransferObj", []string{traceCode, transferId})
+	if err != nil || Avalbytes == nil {
+		return nil, err
+	}
+	var transfer TransferObj
+	err = json.Unmarshal(Avalbytes, &transfer)
+	if err != nil {
+		return nil, err
+	}
+	return &transfer, nil
+}
+
+func PutTransfer(stub shim.ChaincodeStubInterface, transfer TransferObj) ([]byte, error) {
+
+	buff, err := json.Marshal(transfer)
+	if err != nil {
+		return nil, err
+	}
+	err = ReplaceObject(stub, "TransferObj", []string{transfer.TraceCode, transfer.TransferId}, buff)
+	if err != nil {
+		fmt.Println("PutTransfer() : write error while inserting record")
+		return nil, err
+	}
+	return buff, nil
+}
+
+//////////////////////////////////////////////////////////
+// Write the SkuTransactionObj of an accepted transfer, one
+// TraceCode handed to the receiving account
+//////////////////////////////////////////////////////////
+func PutTransferTransaction(stub shim.ChaincodeStubInterface, transfer TransferObj) error {
+
+	sku, err := LoadSkuBaseInfo(stub, transfer.TraceCode)
+	if err != nil {
+		return err
+	}
+	if sku == nil {
+		return errors.New("SkuBaseInfoObj " + transfer.TraceCode + " is not registered")
+	}
+	record := SkuTransactionObj{
+		OrderId:   transfer.TransferId,
+		SkuId:     sku.SkuId,
+		TraceCode: transfer.TraceCode,
+		TransType: TRANS_TYPE_TRANSFER,
+		BatchNum:  sku.BatchNum,
+		AccountNo: transfer.To,
+		Num:       "1",
+		Signature: transfer.ResolveSignature,
+		TransDate: transfer.ResolvedAt,
+		Revision:  "1",
+	}
+	buff, err := SkuTransactionToJSON(record)
+	if err != nil {
+		return err
+	}
+	keys := []string{record.TraceCode, record.SkuId, record.OrderId, record.TransType}
+	return UpdateObject(stub, "SkuTransactionObj", keys, buff)
+}
+
+//////////////////////////////////////////////////////////
+// SkuTransactionObj of TransType Transfer are written only
+// by iAcceptTransfer
+//////////////////////////////////////////////////////////
+func CheckTransType(record SkuTransactionObj) error {
+
+	if record.TransType == TRANS_TYPE_TRANSFER {
+		return fmt.Errorf("%s : TransType %s is recorded by iAcceptTransfer", ErrReservedTransType, record.TransType)
+	}
+	return nil
+}
+
+//////////////////////////////////////////////////////////
+// The custody of traceCode. Before any handoff it is held
+// by the AddressHash of its SkuBaseInfoObj. nil if there
+// is neither
+//////////////////////////////////////////////////////////
+func GetCustody(stub shim.ChaincodeStubInterface, traceCode string) (*CustodyObj, error) {
+
+	Avalbytes, err := QueryObject(stub, "CustodyObj", []string{traceCode})
+	if err != nil {
+		return nil, err
+	}
+	if Avalbytes != nil {
+		var custody CustodyObj
+		err = json.Unmarshal(Avalbytes, &custody)
+		if err != nil {
+			return nil, err
+		}
+		return &custody, nil
+	}
+
+	baseInfo, err := LoadSkuBaseInfo(stub, traceCode)
+	if err != nil || baseInfo == nil || baseInfo.AddressHash == "" {
+		return nil, err
+	}
+	return &CustodyObj{TraceCode: traceCode, Holder: baseInfo.AddressHash, Since: baseInfo.TimeStamp}, nil
+}
+
+func PutCustody(stub shim.ChaincodeStubInterface, custody CustodyObj) error {
+
+	buff, err := json.Marshal(custody)
+	if err != nil {
+		return err
+	}
+	return ReplaceObject(stub, "CustodyObj", []string{custody.TraceCode}, buff)
+}
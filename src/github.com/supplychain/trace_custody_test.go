@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestAcceptedTransferRecord(t *testing.T) {
+
+	farm := newTestSigner(t, "farm")
+	truck := newTestSigner(t, "truck")
+	stub := newTestStub(t)
+	stub.mustTransact(t, "iPostAccountInfo", toJSON(t, farm.account()))
+	stub.mustTransact(t, "iPostAccountInfo", toJSON(t, truck.account()))
+	stub.mustTransact(t, "iPostSkuBaseInfo", toJSON(t, SkuBaseInfoObj{SkuId: "sku1", TraceCode: "tc1", BatchNum: "b1", AddressHash: "farm", TimeStamp: "2017-05-01 00:00:00"}))
+
+	// Each step signed by the account taking it
+	step := func(signer testSigner, message ...string) []string {
+		buff, _ := json.Marshal(message)
+		return append(message[1:], signer.sign(buff))
+	}
+	keys := func(transferId string) []string {
+		return []string{"tc1", "sku1", transferId, TRANS_TYPE_TRANSFER}
+	}
+
+	stub.mustTransact(t, "iProposeTransfer", step(farm, "iProposeTransfer", "tc1", "t1", "farm", "truck")...)
+	if stub.object(t, "SkuTransactionObj", keys("t1")...) != nil {
+		t.Errorf("a proposed transfer was recorded as final")
+	}
+	stub.mustTransact(t, "iRejectTransfer", step(truck, "iRejectTransfer", "tc1", "t1", "damaged")...)
+	if stub.object(t, "SkuTransactionObj", keys("t1")...) != nil {
+		t.Errorf("a rejected transfer was recorded as final")
+	}
+
+	stub.mustTransact(t, "iProposeTransfer", step(farm, "iProposeTransfer", "tc1", "t2", "farm", "truck")...)
+	stub.mustTransact(t, "iAcceptTransfer", step(truck, "iAcceptTransfer", "tc1", "t2")...)
+	var record SkuTransactionObj
+	err := json.Unmarshal(stub.object(t, "SkuTransactionObj", keys("t2")...), &record)
+	if err != nil {
+		t.Fatalf("the accepted transfer was not recorded : %s", err)
+	}
+	if record.AccountNo != "truck" || record.BatchNum != "b1" {
+		t.Errorf("the accepted transfer was recorded for %s/%s, want truck/b1", record.AccountNo, record.BatchNum)
+	}
+
+	// Only iAcceptTransfer writes the final record of a handoff
+	tests := []struct {
+		function string
+		args     []string
+	}{
+		{"iPostSkuTransaction", []string{toJSON(t, testTransaction("t3", TRANS_TYPE_TRANSFER, "1"))}},
+		{"iPostSkuTransactionArrary", []string{toJSON(t, []SkuTransactionObj{testTransaction("t3", TRANS_TYPE_TRANSFER, "1")})}},
+		{"iUpdateSkuTransaction", append(keys("t2"), `{"AccountNo":"farm","Revision":"1"}`)},
+	}
+	for _, test := range tests {
+		r := stub.transact(test.function, test.args...)
+		if r.Status == shim.OK {
+			t.Errorf("%s : a transfer record was written directly", test.function)
+		}
+	}
+}
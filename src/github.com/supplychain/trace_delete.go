@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+///////////////////////////////////////////////////////////////////////////////////////
+//
+// Deleting records
+//
+// A record is never removed from the ledger. iDelete<Type> replaces it with a
+// TombstoneObj carrying the reason, the submitter and the transaction time, and the
+// record as it was. The table functions skip tombstones, so a deleted record is gone
+// from every query, its previous versions stay in qGetObjectHistory. The list queries
+// return the tombstones of their range as Tombstones when called with the trailing
+// flag includeDeleted
+//
+// Every Object type a caller writes can be deleted. The records the chaincode keeps
+// along with another one, such as an InventoryObj or a CustodyObj, follow that one
+// and have no iDelete<Type> of their own
+// peer chaincode query -l golang -n test_trace -c '{"Function": "qGetSkuTraceRecordListByTraceCode", "Args": ["1111", "includeDeleted"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////
+
+var ErrObjectNotFound = errors.New("OBJECT_NOT_FOUND")
+
+// Trailing argument of the list queries asking for the tombstones
+const INCLUDE_DELETED = "includeDeleted"
+
+type TombstoneObj struct {
+	Tombstone  bool // Always true, tells a tombstone from a record
+	ObjectType string
+	Keys       []string
+	Reason     string
+	DeletedBy  string // MSP ID/common name of the submitter
+	DeletedAt  string // Transaction time
+	TxId       string
+	Object     json.RawMessage // The record as it was
+}
+
+// Every tombstone is written with Tombstone as its first attribute
+var tombstonePrefix = []byte(`{"Tombstone":true`)
+
+//////////////////////////////////////////////////////////
+// Returns true if objectData is a TombstoneObj
+//////////////////////////////////////////////////////////
+func IsTombstone(objectData []byte) bool {
+	return bytes.HasPrefix(objectData, tombstonePrefix)
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Replace the Object under keys with its tombstone
+// Returns ErrObjectNotFound if there is no such Object
+////////////////////////////////////////////////////////////////////////////
+func DeleteObject(stub shim.ChaincodeStubInterface, objectType string, keys []string, reason string) ([]byte, error) {
+
+	err := VerifyAllKeysArePresent(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	compositeKey, err := stub.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	current, err := stub.GetState(compositeKey)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil || IsTombstone(current) {
+		return nil, ErrObjectNotFound
+	}
+
+	// Deleting a record needs the same right as writing it
+	err = CheckObjectTypeEnabled(stub, objectType)
+	if err != nil {
+		return nil, err
+	}
+	err = AuthorizeObjectWrite(stub, objectType, compositeKey, current)
+	if err != nil {
+		return nil, err
+	}
+
+	// A deleted record drops out of the secondary indexes
+	err = UpdateIndexes(stub, objectType, keys, compositeKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	now, err := GetTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := GetCallerIdentity(stub)
+	if err != nil {
+		return nil, err
+	}
+	tombstone := TombstoneObj{true, objectType, keys, reason, caller.String(), now.Format(TimeLayout), stub.GetTxID(), current}
+	buff, err := json.Marshal(tombstone)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stub.PutState(compositeKey, buff)
+	if err != nil {
+		fmt.Println("DeleteObject() : Error writing tombstone into State Database ", err)
+		return nil, err
+	}
+	return buff, nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Delete the record of objectType named by args, its keys followed by the
+// reason
+////////////////////////////////////////////////////////////////////////////
+func DeleteRecord(stub shim.ChaincodeStubInterface, caller string, objectType string, args []string) pb.Response {
+
+	nKeys := GetNumberOfKeys(objectType)
+	if len(args) != nKeys+1 {
+		return shim.Error(fmt.Sprintf("%s(): Incorrect number of arguments. Expecting the %d keys of %s and Reason", caller, nKeys, objectType))
+	}
+	keys, reason := args[:nKeys], args[nKeys]
+	if reason == "" {
+		return shim.Error(caller + "(): Reason can not be empty")
+	}
+
+	buff, err := DeleteObject(stub, objectType, keys, reason)
+	if err == ErrObjectNotFound {
+		return NotFound(caller + "(): " + objectType + " " + fmt.Sprint(keys))
+	}
+	if err != nil {
+		fmt.Println(caller + "() : write error while deleting record")
+		return shim.Error(caller + "(): " + err.Error())
+	}
+	err = EmitObjectEvent(stub, EVENT_OBJECT_DELETED, objectType, keys, buff)
+	if err != nil {
+		return shim.Error(caller + "(): " + err.Error())
+	}
+	return shim.Success(buff)
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Delete a SkuTraceRecordObj
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iDeleteSkuTraceRecord", "Args":["TraceCode", "SkuId",
+// "AddressHash", "StationType", "Reason"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func DeleteSkuTraceRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return DeleteRecord(stub, "DeleteSkuTraceRecord", "SkuTraceRecordObj", args)
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Delete a SkuAuthenticationTraceRecordObj
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iDeleteSkuAuthenticationTraceRecord", "Args":["TraceCode", "SkuId",
+// "AddressHash", "CertificationBodyType", "Reason"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func DeleteSkuAuthenticationTraceRecord(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return DeleteRecord(stub, "DeleteSkuAuthenticationTraceRecord", "SkuAuthenticationTraceRecordObj", args)
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Delete a SkuBaseInfoObj
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iDeleteSkuBaseInfo", "Args":["TraceCode", "Reason"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func DeleteSkuBaseInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return DeleteRecord(stub, "DeleteSkuBaseInfo", "SkuBaseInfoObj", args)
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Delete a SkuTransactionObj, taking its Num back out of the inventory
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iDeleteSkuTransaction", "Args":["TraceCode", "SkuId",
+// "OrderId", "TransType", "Reason"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func DeleteSkuTransaction(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) == GetNumberOfKeys("SkuTransactionObj")+1 {
+		previous, err := GetSkuTransaction(stub, args[:len(args)-1])
+		if err != nil {
+			return shim.Error("DeleteSkuTransaction(): " + err.Error())
+		}
+		if previous != nil {
+			ledger := NewInventoryLedger(stub)
+			err = ledger.Replace(previous, nil)
+			if err == nil {
+				err = ledger.Flush()
+			}
+			if err != nil {
+				return shim.Error("DeleteSkuTransaction(): " + err.Error())
+			}
+		}
+	}
+	return DeleteRecord(stub, "DeleteSkuTransaction", "SkuTransactionObj", args)
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Delete an AccountInfoObj
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iDeleteAccountInfo", "Args":["Name", "Reason"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func DeleteAccountInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return DeleteRecord(stub, "DeleteAccountInfo", "AccountInfoObj", args)
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Delete a CertificationAccountInfoObj
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iDeleteCertificationAccountInfo", "Args":["Name", "Reason"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func DeleteCertificationAccountInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return DeleteRecord(stub, "DeleteCertificationAccountInfo", "CertificationAccountInfoObj", args)
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Delete a RecallObj. The TraceCodes and batch it covered are no longer recalled by it
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iDeleteRecall", "Args":["RecallId", "Reason"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func DeleteRecall(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return DeleteRecord(stub, "DeleteRecall", "RecallObj", args)
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Delete a TraceCodeLinkObj, under both of its ends
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iDeleteTraceCodeLink", "Args":["Child", "Parent",
+// "AggregatedAt", "TxId", "Reason"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func DeleteTraceCodeLink(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) == GetNumberOfKeys("TraceCodeLinkObj")+1 {
+		link := TraceCodeLinkObj{Child: args[0], Parent: args[1], AggregatedAt: args[2], TxId: args[3]}
+		_, err := DeleteObject(stub, "TraceCodeChildObj", link.childKeys(), args[4])
+		if err != nil && err != ErrObjectNotFound {
+			return shim.Error("DeleteTraceCodeLink(): " + err.Error())
+		}
+	}
+	return DeleteRecord(stub, "DeleteTraceCodeLink", "TraceCodeLinkObj", args)
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Delete a TransferObj
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iDeleteTransfer", "Args":["TraceCode", "TransferId", "Reason"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func DeleteTransfer(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return DeleteRecord(stub, "DeleteTransfer", "TransferObj", args)
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Delete a SensorReadingObj, the violation flag of its TraceCode is counted again
+// without it
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iDeleteSensorReading", "Args":["TraceCode", "StationName",
+// "ReadAt", "SensorId", "Reason"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func DeleteSensorReading(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	response := DeleteRecord(stub, "DeleteSensorReading", "SensorReadingObj", args)
+	if response.Status != shim.OK {
+		return response
+	}
+	err := RecountSensorViolation(stub, args[0], args[:len(args)-1], args[len(args)-1])
+	if err != nil {
+		return shim.Error("DeleteSensorReading(): " + err.Error())
+	}
+	return response
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Delete a SensorThresholdObj, the readings of its SkuId are no longer checked
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iDeleteSensorThreshold", "Args":["SkuId", "Reason"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func DeleteSensorThreshold(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	return DeleteRecord(stub, "DeleteSensorThreshold", "SensorThresholdObj", args)
+}
+
+//////////////////////////////////////////////////////////
+// Splits the trailing includeDeleted flag off args
+//////////////////////////////////////////////////////////
+func IncludeDeletedArg(args []string) ([]string, bool) {
+
+	if len(args) > 0 && args[len(args)-1] == INCLUDE_DELETED {
+		return args[:len(args)-1], true
+	}
+	return args, false
+}
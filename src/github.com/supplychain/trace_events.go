@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+///////////////////////////////////////////////////////////////////////////////////////
+//
+// Chaincode events
+//
+// Every successful write emits one event carrying the keys and the Object written.
+// Fabric keeps a single event per transaction, so the array endpoints emit one
+// batched event listing every record. Init emits ChaincodeInitialized listing the
+// access policy and the configuration it stored, the batch has no ObjectType then
+//
+//     {"ObjectType":"SkuTraceRecordObj","Keys":["TraceCode","SkuId","AddressHash","StationType"],"Object":{...}}
+//     {"ObjectType":"SkuTraceRecordObj","Records":[{"ObjectType":...,"Keys":[...],"Object":{...}}, ...]}
+///////////////////////////////////////////////////////////////////////////////////////
+
+const (
+	EVENT_ACCOUNT_INFO_POSTED                = "AccountInfoPosted"
+	EVENT_ACCOUNT_INFO_UPDATED               = "AccountInfoUpdated"
+	EVENT_ACCOUNT_KEY_ROTATED                = "AccountKeyRotated"
+	EVENT_CERTIFICATION_ACCOUNT_INFO_POSTED  = "CertificationAccountInfoPosted"
+	EVENT_CERTIFICATION_ACCOUNT_INFO_UPDATED = "CertificationAccountInfoUpdated"
+	EVENT_CERTIFICATION_ACCOUNT_REVOKED      = "CertificationAccountRevoked"
+	EVENT_CERTIFICATION_ACCOUNT_SUSPENDED    = "CertificationAccountSuspended"
+	EVENT_BASE_INFO_POSTED                   = "BaseInfoPosted"
+	EVENT_BASE_INFO_UPDATED                  = "BaseInfoUpdated"
+	EVENT_TRANSACTION_POSTED                 = "TransactionPosted"
+	EVENT_TRANSACTIONS_POSTED                = "TransactionsPosted"
+	EVENT_TRANSACTION_UPDATED                = "TransactionUpdated"
+	EVENT_AUTHENTICATION_RECORD_POSTED       = "AuthenticationRecordPosted"
+	EVENT_AUTHENTICATION_RECORD_UPDATED      = "AuthenticationRecordUpdated"
+	EVENT_TRACE_RECORD_POSTED                = "TraceRecordPosted"
+	EVENT_TRACE_RECORDS_POSTED               = "TraceRecordsPosted"
+	EVENT_TRACE_RECORD_UPDATED               = "TraceRecordUpdated"
+	EVENT_ACCESS_POLICY_SET                  = "AccessPolicySet"
+	EVENT_CHANNEL_SETTING_SET                = "ChannelSettingSet"
+	EVENT_CHAINCODE_CONFIG_SET               = "ChaincodeConfigSet"
+	EVENT_CHAINCODE_INITIALIZED              = "ChaincodeInitialized"
+	EVENT_RECALL_POSTED                      = "RecallPosted"
+	EVENT_RECALL_CLOSED                      = "RecallClosed"
+	EVENT_AGGREGATED                         = "Aggregated"
+	EVENT_DISAGGREGATED                      = "Disaggregated"
+	EVENT_TRANSFER_PROPOSED                  = "TransferProposed"
+	EVENT_TRANSFER_ACCEPTED                  = "TransferAccepted"
+	EVENT_TRANSFER_REJECTED                  = "TransferRejected"
+	EVENT_SENSOR_READINGS_POSTED             = "SensorReadingsPosted"
+	EVENT_SENSOR_THRESHOLD_SET               = "SensorThresholdSet"
+	EVENT_OBJECT_DELETED                     = "ObjectDeleted"
+)
+
+//////////////////////////////////////////////////////////
+// Payload of a single record event
+//////////////////////////////////////////////////////////
+type ObjectEvent struct {
+	ObjectType string
+	Keys       []string
+	Object     json.RawMessage
+}
+
+//////////////////////////////////////////////////////////
+// Payload of a batched event
+//////////////////////////////////////////////////////////
+type ObjectBatchEvent struct {
+	ObjectType string `json:",omitempty"`
+	Records    []ObjectEvent
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Emit name for a single Object written under keys
+////////////////////////////////////////////////////////////////////////////
+func EmitObjectEvent(stub shim.ChaincodeStubInterface, name string, objectType string, keys []string, objectData []byte) error {
+
+	payload, err := json.Marshal(ObjectEvent{objectType, keys, objectData})
+	if err != nil {
+		return err
+	}
+	return emitEvent(stub, name, payload)
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Emit name for all the records written by an array endpoint
+////////////////////////////////////////////////////////////////////////////
+func EmitObjectBatchEvent(stub shim.ChaincodeStubInterface, name string, objectType string, records []ObjectEvent) error {
+
+	payload, err := json.Marshal(ObjectBatchEvent{objectType, records})
+	if err != nil {
+		return err
+	}
+	return emitEvent(stub, name, payload)
+}
+
+func emitEvent(stub shim.ChaincodeStubInterface, name string, payload []byte) error {
+
+	err := stub.SetEvent(name, payload)
+	if err != nil {
+		fmt.Println("emitEvent() : Failed to set event ", name, " : ", err)
+		return err
+	}
+	fmt.Println("emitEvent() : ", name)
+	return nil
+}
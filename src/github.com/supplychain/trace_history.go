@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+///////////////////////////////////////////////////////////////////////////////////////
+//
+// Object history
+//
+// The history of a key only carries the TxId and the value of each version. Every
+// invoke transaction that succeeds also keeps one TxInfoObj with its time, submitter and
+// function, so each version can be told when and by whom it was written. The TxInfoObj
+// are never deleted: the ledger grows by one of them, under 200 bytes, per invoke.
+// Versions written before the TxInfoObj were kept, or by Init, have an empty TimeStamp
+// and Submitter, and so has the Submitter of a transaction whose creator can not be read
+///////////////////////////////////////////////////////////////////////////////////////
+
+type TxInfoObj struct {
+	TxId      string
+	TimeStamp string // Transaction time
+	Submitter string // MSP ID/common name of the submitter
+	Function  string
+}
+
+type ObjectVersion struct {
+	TxId      string
+	TimeStamp string
+	Submitter string
+	Function  string
+	IsDelete  bool
+	Object    json.RawMessage `json:",omitempty"` // The tombstone of a deleted Object
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+// Get every version of an Object, oldest first
+// peer chaincode query -l golang -n test_trace -c '{"Function": "qGetObjectHistory", "Args": ["SkuBaseInfoObj", "1111"]}' -o orderer0:7050
+// The key parts are those of the Object, eg: for a SkuTraceRecordObj
+// peer chaincode query -l golang -n test_trace -c '{"Function": "qGetObjectHistory", "Args": ["SkuTraceRecordObj", "TraceCode",
+// "SkuId", "AddressHash", "StationType"]}' -o orderer0:7050
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+func GetObjectHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 2 {
+		return shim.Error("Incorrect number of arguments. Expecting ObjectType and its keys")
+	}
+
+	modifications, err := QueryObjectHistory(stub, args[0], args[1:])
+	if err != nil {
+		error_str := fmt.Sprintf("GetObjectHistory() operation failed. %s", err)
+		fmt.Println(error_str)
+		return shim.Error(error_str)
+	}
+	if len(modifications) == 0 {
+		return NotFound("GetObjectHistory(): " + args[0] + " " + fmt.Sprint(args[1:]))
+	}
+
+	txInfos := map[string]*TxInfoObj{}
+	versions := []ObjectVersion{}
+	for _, modification := range modifications {
+		info, ok := txInfos[modification.TxId]
+		if !ok {
+			info, err = GetTxInfo(stub, modification.TxId)
+			if err != nil {
+				return shim.Error("GetObjectHistory() operation failed. " + err.Error())
+			}
+			txInfos[modification.TxId] = info
+		}
+
+		// A deleted Object leaves its tombstone, see trace_delete.go
+		version := ObjectVersion{TxId: modification.TxId, IsDelete: len(modification.Value) == 0 || IsTombstone(modification.Value)}
+		if info != nil {
+			version.TimeStamp = info.TimeStamp
+			version.Submitter = info.Submitter
+			version.Function = info.Function
+		}
+		if len(modification.Value) > 0 {
+			version.Object = modification.Value
+		}
+		versions = append(versions, version)
+	}
+
+	buff, err := json.Marshal(versions)
+	if err != nil {
+		return shim.Error("GetObjectHistory() operation failed - Marshall Error. " + err.Error())
+	}
+	return shim.Success(buff)
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Keep the TxInfoObj of the current transaction, once Invoke ran it. Written
+// directly, so it does not go through the access policy
+////////////////////////////////////////////////////////////////////////////
+func RecordTxInfo(stub shim.ChaincodeStubInterface) error {
+
+	now, err := GetTxTime(stub)
+	if err != nil {
+		return err
+	}
+	submitter := ""
+	caller, err := GetCallerIdentity(stub)
+	if err != nil {
+		fmt.Println("RecordTxInfo() : Keeping no submitter : ", err)
+	} else {
+		submitter = caller.String()
+	}
+	function, _ := stub.GetFunctionAndParameters()
+
+	info := TxInfoObj{stub.GetTxID(), now.Format(TimeLayout), submitter, function}
+	buff, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	compositeKey, err := stub.CreateCompositeKey("TxInfoObj", []string{info.TxId})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(compositeKey, buff)
+}
+
+//////////////////////////////////////////////////////////
+// The TxInfoObj of txId, nil if there is none
+//////////////////////////////////////////////////////////
+func GetTxInfo(stub shim.ChaincodeStubInterface, txId string) (*TxInfoObj, error) {
+
+	Avalbytes, err := QueryObject(stub, "TxInfoObj", []string{txId})
+	if err != nil || Avalbytes == nil {
+		return nil, err
+	}
+	var info TxInfoObj
+	err = json.Unmarshal(Avalbytes, &info)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+// Get the Objects of ObjectType by one of its secondary indexes, see Indexes in table_1.0api.go.
+// The values are the leading attributes of the index, eg: the SkuId index of a SkuTraceRecordObj
+// is on SkuId and BatchNum and can be queried by SkuId alone or by both
+// peer chaincode query -l golang -n test_trace -c '{"Function": "qGetByIndex", "Args": ["SkuTraceRecordObj", "SkuId",
+// "SkuId", "BatchNum"]}' -o orderer0:7050
+// peer chaincode query -l golang -n test_trace -c '{"Function": "qGetByIndex", "Args": ["SkuTransactionObj", "OrderId", "OrderId"]}' -o orderer0:7050
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+func GetByIndexInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 3 {
+		return shim.Error("Incorrect number of arguments. Expecting ObjectType, IndexName and at least one value")
+	}
+
+	values, err := GetByIndex(stub, args[0], args[1], args[2:])
+	if err != nil {
+		error_str := fmt.Sprintf("GetByIndexInfo() operation failed. %s", err)
+		fmt.Println(error_str)
+		return shim.Error(error_str)
+	}
+
+	buff, err := json.Marshal(RawMessages(values))
+	if err != nil {
+		return shim.Error("GetByIndexInfo() operation failed - Marshall Error. " + err.Error())
+	}
+	return shim.Success(buff)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"strconv"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////////////
+//
+// Inventory
+//
+// Every SkuTransactionObj moves the balance of its AccountNo, SkuId and BatchNum by
+// its Num, a whole number of units
+//     Buy        : adds Num
+//     Sale       : removes Num
+//     Commission : and any other TransType, no change
+// Updating a transaction takes back its old effect and applies the new one. A write
+// that would leave a balance below zero is rejected.
+//
+// Balances start at zero, transactions posted before the balances were kept are not
+// counted
+///////////////////////////////////////////////////////////////////////////////////////
+
+var ErrInsufficientInventory = errors.New("INSUFFICIENT_INVENTORY")
+
+type InventoryObj struct {
+	AccountNo string
+	SkuId     string
+	BatchNum  string
+	Balance   string
+	TimeStamp string // Transaction time of the last change
+}
+
+//////////////////////////////////////////////////////////
+// Balances changed by one transaction. Reads do not see
+// the writes of the transaction, so the balances are
+// kept here until Flush
+//////////////////////////////////////////////////////////
+type InventoryLedger struct {
+	stub     shim.ChaincodeStubInterface
+	balances map[string]*InventoryObj
+	amounts  map[string]int64
+}
+
+func NewInventoryLedger(stub shim.ChaincodeStubInterface) *InventoryLedger {
+	return &InventoryLedger{stub, map[string]*InventoryObj{}, map[string]int64{}}
+}
+
+//////////////////////////////////////////////////////////
+// The change a transaction makes to its balance
+//////////////////////////////////////////////////////////
+func InventoryEffect(record SkuTransactionObj) (int64, error) {
+
+	if record.TransType != "Buy" && record.TransType != "Sale" {
+		return 0, nil
+	}
+	num, err := strconv.ParseInt(record.Num, 10, 64)
+	if err != nil || num < 0 {
+		return 0, errors.New("Num must be a whole number of units : " + record.Num)
+	}
+	if record.TransType == "Sale" {
+		return -num, nil
+	}
+	return num, nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Replace the effect of previous with the effect of record
+// previous is nil for a new transaction, record is nil when previous goes
+////////////////////////////////////////////////////////////////////////////
+func (l *InventoryLedger) Replace(previous *SkuTransactionObj, record *SkuTransactionObj) error {
+
+	changes := []struct {
+		sign   int64
+		record *SkuTransactionObj
+	}{{-1, previous}, {1, record}}
+
+	var touched []string
+	for _, change := range changes {
+		if change.record == nil {
+			continue
+		}
+		effect, err := InventoryEffect(*change.record)
+		if err != nil {
+			return err
+		}
+		if effect == 0 {
+			continue
+		}
+		key, err := l.load(change.record.AccountNo, change.record.SkuId, change.record.BatchNum)
+		if err != nil {
+			return err
+		}
+		l.amounts[key] += change.sign * effect
+		touched = append(touched, key)
+	}
+
+	for _, key := range touched {
+		if l.amounts[key] < 0 {
+			inv := l.balances[key]
+			return fmt.Errorf("%s : %s would hold %d of %s batch %s", ErrInsufficientInventory, inv.AccountNo, l.amounts[key], inv.SkuId, inv.BatchNum)
+		}
+	}
+	return nil
+}
+
+func (l *InventoryLedger) load(accountNo string, skuId string, batchNum string) (string, error) {
+
+	key := strings.Join([]string{accountNo, skuId, batchNum}, "\x00")
+	if _, ok := l.balances[key]; ok {
+		return key, nil
+	}
+	inv := &InventoryObj{AccountNo: accountNo, SkuId: skuId, BatchNum: batchNum, Balance: "0"}
+	Avalbytes, err := QueryObject(l.stub, "InventoryObj", []string{accountNo, skuId, batchNum})
+	if err != nil {
+		return "", err
+	}
+	if Avalbytes != nil {
+		err = json.Unmarshal(Avalbytes, inv)
+		if err != nil {
+			return "", err
+		}
+	}
+	amount, err := strconv.ParseInt(inv.Balance, 10, 64)
+	if err != nil {
+		return "", errors.New("InventoryObj balance is not a number : " + inv.Balance)
+	}
+	l.balances[key] = inv
+	l.amounts[key] = amount
+	return key, nil
+}
+
+//////////////////////////////////////////////////////////
+// Write the balances changed
+//////////////////////////////////////////////////////////
+func (l *InventoryLedger) Flush() error {
+
+	now, err := GetTxTime(l.stub)
+	if err != nil {
+		return err
+	}
+	for key, inv := range l.balances {
+		balance := strconv.FormatInt(l.amounts[key], 10)
+		if balance == inv.Balance {
+			continue
+		}
+		inv.Balance = balance
+		inv.TimeStamp = now.Format(TimeLayout)
+		buff, err := json.Marshal(inv)
+		if err != nil {
+			return err
+		}
+		err = ReplaceObject(l.stub, "InventoryObj", []string{inv.AccountNo, inv.SkuId, inv.BatchNum}, buff)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+// Get the balances of an account, of every SkuId or of one
+// peer chaincode query -l golang -n test_trace -c '{"Function": "qGetInventory", "Args": ["AccountNo", "SkuId"]}' -o orderer0:7050
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+func GetInventory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) < 1 || len(args) > 2 {
+		return shim.Error("Incorrect number of arguments. Expecting AccountNo [, SkuId]")
+	}
+
+	values, err := GetListValues(stub, "InventoryObj", args)
+	if err != nil {
+		return shim.Error("GetInventory() operation failed. " + err.Error())
+	}
+	var tlist []InventoryObj
+	for _, value := range values {
+		var inv InventoryObj
+		err = json.Unmarshal(value, &inv)
+		if err != nil {
+			return shim.Error("GetInventory() operation failed - Unmarshall Error. " + err.Error())
+		}
+		tlist = append(tlist, inv)
+	}
+	buff, err := json.Marshal(tlist)
+	if err != nil {
+		return shim.Error("GetInventory() operation failed - Marshall Error. " + err.Error())
+	}
+	return shim.Success(buff)
+}
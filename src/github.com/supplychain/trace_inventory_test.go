@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// The balance of sku1 batch b1 held by a1, "" if there is none
+func testBalance(t *testing.T, stub *testStub) string {
+
+	var balances []InventoryObj
+	payload := stub.mustTransact(t, "qGetInventory", "a1", "sku1")
+	err := json.Unmarshal(payload, &balances)
+	if err != nil {
+		t.Fatalf("qGetInventory : %s : %s", err, payload)
+	}
+	for _, inv := range balances {
+		if inv.BatchNum == "b1" {
+			return inv.Balance
+		}
+	}
+	return ""
+}
+
+func TestInventoryBalance(t *testing.T) {
+
+	stub := newTestStub(t)
+	buy := testTransaction("o1", "Buy", "10")
+	sale := testTransaction("o2", "Sale", "4")
+	oversale := testTransaction("o3", "Sale", "7")
+	twice := testTransaction("o4", "Sale", "1")
+
+	tests := []struct {
+		function string
+		args     []string
+		status   int32
+		balance  string
+	}{
+		{"iPostSkuTransaction", []string{toJSON(t, buy)}, shim.OK, "10"},
+		{"iPostSkuTransaction", []string{toJSON(t, sale)}, shim.OK, "6"},
+		{"iPostSkuTransaction", []string{toJSON(t, oversale)}, shim.ERROR, "6"},
+		{"iPostSkuTransaction", []string{toJSON(t, testTransaction("o5", "Commission", "100"))}, shim.OK, "6"},
+		{"iPostSkuTransaction", []string{toJSON(t, testTransaction("o6", "Buy", "-1"))}, shim.ERROR, "6"},
+		{"iUpdateSkuTransaction", []string{"tc1", "sku1", "o1", "Buy", `{"Num":"3","Revision":"1"}`}, shim.ERROR, "6"},
+		{"iUpdateSkuTransaction", []string{"tc1", "sku1", "o1", "Buy", `{"Num":"4","Revision":"1"}`}, shim.OK, "0"},
+		{"iUpdateSkuTransaction", []string{"tc1", "sku1", "o2", "Sale", `{"Num":"1","Revision":"1"}`}, shim.OK, "3"},
+		{"iPostSkuTransactionArrary", []string{toJSON(t, []SkuTransactionObj{twice, twice})}, CONFLICT, "3"},
+		{"iPostSkuTransactionArrary", []string{toJSON(t, []SkuTransactionObj{testTransaction("o7", "Sale", "2"), testTransaction("o8", "Sale", "2")})}, shim.ERROR, "3"},
+		{"iPostSkuTransactionArrary", []string{toJSON(t, []SkuTransactionObj{testTransaction("o7", "Sale", "2"), testTransaction("o8", "Buy", "2")})}, shim.OK, "3"},
+		{"iDeleteSkuTransaction", []string{"tc1", "sku1", "o2", "Sale", "cancelled"}, shim.OK, "4"},
+	}
+
+	for _, test := range tests {
+		r := stub.transact(test.function, test.args...)
+		if r.Status != test.status {
+			t.Errorf("%s%q : status %d, want %d : %s", test.function, test.args, r.Status, test.status, r.Message)
+		}
+		if balance := testBalance(t, stub); balance != test.balance {
+			t.Errorf("after %s%q : balance %s, want %s", test.function, test.args, balance, test.balance)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"sort"
+)
+
+///////////////////////////////////////////////////////////////////////////////////////
+//
+// The journey of a TraceCode
+//
+// Everything recorded against a TraceCode in one document: the SkuBaseInfoObj, the
+// SkuTraceRecordObj list in the order the SKU went through the stations, then the
+// authentications and the transactions.
+//
+// The trace records are chained: a record follows the one whose StationName is its
+// PreStation, or whose NextStation is its StationName. The chain starts at the
+// record with the earliest BeginTime, and when several records could follow, the
+// earliest one is taken. Where no record follows, the chain is broken, a Gap is
+// reported and the chain resumes at the earliest remaining record.
+//
+// The trace records include the ones inherited from the TraceCodes it was packed
+// into, see trace_aggregation.go. Containers lists the links followed.
+//
+// Sensors holds the cold-chain readings of the TraceCode and its violation flag,
+// see trace_sensor.go
+///////////////////////////////////////////////////////////////////////////////////////
+
+//////////////////////////////////////////////////////////
+// A break in the chain of trace records
+//////////////////////////////////////////////////////////
+type SkuJourneyGap struct {
+	After  string // StationName of the last record before the gap
+	Before string // StationName of the first record after the gap
+	Index  int    // Position in TraceRecords of the record after the gap
+}
+
+type SkuJourneyObj struct {
+	TraceCode       string
+	BaseInfo        *SkuBaseInfoObj
+	TraceRecords    []SkuTraceRecordObj
+	Gaps            []SkuJourneyGap
+	Containers      []TraceCodeLinkObj
+	Authentications []SkuAuthenticationTraceRecordView
+	Transactions    []SkuTransactionObj
+	Sensors         SensorReportObj
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+// Get the journey of a TraceCode
+// peer chaincode query -l golang -n test_trace -c '{"Function": "qGetSkuJourney", "Args": ["1111"]}' -o orderer0:7050
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+func GetSkuJourney(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	journey, err := BuildSkuJourney(stub, args[0])
+	if err != nil {
+		error_str := fmt.Sprintf("GetSkuJourney() operation failed. %s", err)
+		fmt.Println(error_str)
+		return shim.Error(error_str)
+	}
+	if journey.BaseInfo == nil && len(journey.TraceRecords) == 0 && len(journey.Authentications) == 0 && len(journey.Transactions) == 0 &&
+		len(journey.Sensors.Readings) == 0 {
+		return NotFound("GetSkuJourney() : nothing recorded for TraceCode " + args[0])
+	}
+
+	buff, err := json.Marshal(journey)
+	if err != nil {
+		error_str := fmt.Sprintf("GetSkuJourney() operation failed - Marshall Error. %s", err)
+		fmt.Println(error_str)
+		return shim.Error(error_str)
+	}
+	return shim.Success(buff)
+}
+
+//////////////////////////////////////////////////////////
+// Assemble the journey of traceCode
+//////////////////////////////////////////////////////////
+func BuildSkuJourney(stub shim.ChaincodeStubInterface, traceCode string) (SkuJourneyObj, error) {
+
+	journey := SkuJourneyObj{TraceCode: traceCode}
+
+	baseInfo, err := LoadSkuBaseInfo(stub, traceCode)
+	if err != nil {
+		return journey, err
+	}
+	journey.BaseInfo = baseInfo
+
+	records, err := LoadSkuTraceRecords(stub, traceCode)
+	if err != nil {
+		return journey, err
+	}
+	inherited, containers, err := LoadInheritedSkuTraceRecords(stub, traceCode)
+	if err != nil {
+		return journey, err
+	}
+	records = append(records, inherited...)
+	journey.Containers = containers
+	journey.TraceRecords, journey.Gaps = ChainSkuTraceRecords(records)
+
+	now, err := GetTxTime(stub)
+	if err != nil {
+		return journey, err
+	}
+	auths, err := LoadSkuAuthenticationTraceRecords(stub, traceCode)
+	if err != nil {
+		return journey, err
+	}
+	journey.Authentications, err = NewCertificationBodies(stub).SkuAuthenticationViews(auths, now)
+	if err != nil {
+		return journey, err
+	}
+
+	journey.Transactions, err = LoadSkuTransactions(stub, traceCode)
+	if err != nil {
+		return journey, err
+	}
+	sort.Stable(byTransDate(journey.Transactions))
+
+	journey.Sensors, err = LoadSensorReport(stub, traceCode)
+	if err != nil {
+		return journey, err
+	}
+
+	return journey, nil
+}
+
+// SkuTransactionObj in TransDate order
+type byTransDate []SkuTransactionObj
+
+func (t byTransDate) Len() int           { return len(t) }
+func (t byTransDate) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t byTransDate) Less(i, j int) bool { return t[i].TransDate < t[j].TransDate }
+
+// SkuTraceRecordObj in BeginTime order
+type byBeginTime []SkuTraceRecordObj
+
+func (r byBeginTime) Len() int           { return len(r) }
+func (r byBeginTime) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byBeginTime) Less(i, j int) bool { return r[i].BeginTime < r[j].BeginTime }
+
+////////////////////////////////////////////////////////////////////////////
+// Order trace records along the PreStation/NextStation chain
+// Returns the ordered records and the places the chain is broken
+////////////////////////////////////////////////////////////////////////////
+func ChainSkuTraceRecords(records []SkuTraceRecordObj) ([]SkuTraceRecordObj, []SkuJourneyGap) {
+
+	// Times share one layout, so they sort as strings
+	remaining := make([]SkuTraceRecordObj, len(records))
+	copy(remaining, records)
+	sort.Stable(byBeginTime(remaining))
+
+	var chain []SkuTraceRecordObj
+	var gaps []SkuJourneyGap
+	for len(remaining) > 0 {
+		next := 0
+		if len(chain) > 0 {
+			last := chain[len(chain)-1]
+			next = -1
+			for i, rec := range remaining {
+				if FollowsStation(last, rec) {
+					next = i
+					break
+				}
+			}
+			if next < 0 {
+				next = 0
+				gaps = append(gaps, SkuJourneyGap{After: last.StationName, Before: remaining[0].StationName, Index: len(chain)})
+			}
+		}
+		chain = append(chain, remaining[next])
+		remaining = append(remaining[:next], remaining[next+1:]...)
+	}
+	return chain, gaps
+}
+
+//////////////////////////////////////////////////////////
+// Whether rec is the station after last
+//////////////////////////////////////////////////////////
+func FollowsStation(last SkuTraceRecordObj, rec SkuTraceRecordObj) bool {
+
+	if rec.PreStation != "" && rec.PreStation == last.StationName {
+		return true
+	}
+	return last.NextStation != "" && last.NextStation == rec.StationName
+}
+
+//////////////////////////////////////////////////////////
+// The SkuBaseInfoObj of traceCode, nil if there is none
+//////////////////////////////////////////////////////////
+func LoadSkuBaseInfo(stub shim.ChaincodeStubInterface, traceCode string) (*SkuBaseInfoObj, error) {
+
+	Avalbytes, err := QueryObject(stub, "SkuBaseInfoObj", []string{traceCode})
+	if err != nil || Avalbytes == nil {
+		return nil, err
+	}
+	rec, err := JSONtoSkuBaseInfoObj(Avalbytes)
+	if err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+//////////////////////////////////////////////////////////
+// All the SkuTraceRecordObj of traceCode, in key order
+//////////////////////////////////////////////////////////
+func LoadSkuTraceRecords(stub shim.ChaincodeStubInterface, traceCode string) ([]SkuTraceRecordObj, error) {
+
+	values, err := GetListValues(stub, "SkuTraceRecordObj", []string{traceCode})
+	if err != nil {
+		return nil, err
+	}
+	var records []SkuTraceRecordObj
+	for _, value := range values {
+		rec, err := JSONtoSkuTraceRecordObj(value)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+//////////////////////////////////////////////////////////
+// All the SkuAuthenticationTraceRecordObj of traceCode
+//////////////////////////////////////////////////////////
+func LoadSkuAuthenticationTraceRecords(stub shim.ChaincodeStubInterface, traceCode string) ([]SkuAuthenticationTraceRecordObj, error) {
+
+	values, err := GetListValues(stub, "SkuAuthenticationTraceRecordObj", []string{traceCode})
+	if err != nil {
+		return nil, err
+	}
+	var records []SkuAuthenticationTraceRecordObj
+	for _, value := range values {
+		rec, err := JSONtoSkuAuthenticationTraceRecordObj(value)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+//////////////////////////////////////////////////////////
+// All the SkuTransactionObj of traceCode, in key order
+//////////////////////////////////////////////////////////
+func LoadSkuTransactions(stub shim.ChaincodeStubInterface, traceCode string) ([]SkuTransactionObj, error) {
+
+	values, err := GetListValues(stub, "SkuTransactionObj", []string{traceCode})
+	if err != nil {
+		return nil, err
+	}
+	var records []SkuTransactionObj
+	for _, value := range values {
+		rec, err := JSONtoSkuTransactionObj(value)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
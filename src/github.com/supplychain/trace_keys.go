@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"time"
+)
+
+///////////////////////////////////////////////////////////////////////////////////////
+//
+// Account key history
+//
+// Every PublicKey an AccountInfoObj has signed with is kept as an AccountKeyObj,
+// valid from ValidFrom until the ValidFrom of the next key. A record signature is
+// checked against the key valid at the record TimeStamp, so records signed with an
+// older key stay attributed to the account.
+//
+// The first key of an account is valid from FIRST_KEY_VALID_FROM. Accounts registered
+// before the history was kept get their PublicKey recorded as first key when they
+// rotate. A new key is registered with iRotateAccountKey only, signed by the current
+// key: iPostAccountInfo/iUpdateAccountInfo of a registered account must keep its
+// PublicKey. The PublicKey of the AccountInfoObj is the latest registered key
+///////////////////////////////////////////////////////////////////////////////////////
+
+const FIRST_KEY_VALID_FROM = "0001-01-01 00:00:00"
+
+var (
+	ErrNoValidKey         = errors.New("NO_VALID_KEY")
+	ErrKeyChangeNotSigned = errors.New("KEY_CHANGE_NOT_SIGNED")
+)
+
+type AccountKeyObj struct {
+	Name       string
+	PublicKey  string
+	ValidFrom  string
+	ValidUntil string // ValidFrom of the next key, empty for the latest key
+	TimeStamp  string // Transaction time the key was registered
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Register a new key for an account, valid from ValidFrom, which can not be before the
+// transaction time. Signature is made with the current key over the JSON array
+//     ["iRotateAccountKey","Name","PublicKey","ValidFrom"]
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iRotateAccountKey", "Args":["Name", "PublicKey",
+// "ValidFrom", "Signature"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func RotateAccountKey(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 4 {
+		return shim.Error("RotateAccountKey(): Incorrect number of arguments. Expecting Name, PublicKey, ValidFrom and Signature")
+	}
+	name, publicKey, validFrom, signature := args[0], args[1], args[2], args[3]
+
+	_, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return shim.Error("RotateAccountKey(): " + (&SignatureError{Err: ErrUnsupportedPublicKey, Signer: name, Detail: err.Error()}).Error())
+	}
+	from, err := time.Parse(TimeLayout, validFrom)
+	if err != nil {
+		return shim.Error("RotateAccountKey(): ValidFrom is not a valid time : " + validFrom)
+	}
+	now, err := GetTxTime(stub)
+	if err != nil {
+		return shim.Error("RotateAccountKey(): " + err.Error())
+	}
+	if from.Before(now.Truncate(time.Second)) {
+		return shim.Error("RotateAccountKey(): ValidFrom " + validFrom + " is before the transaction time")
+	}
+
+	acc, err := GetAccountInfoObj(stub, name)
+	if err != nil {
+		return shim.Error("RotateAccountKey(): " + err.Error())
+	}
+	if acc == nil {
+		return NotFound("RotateAccountKey(): AccountInfoObj " + name)
+	}
+
+	if signature == "" {
+		return shim.Error("RotateAccountKey(): " + (&SignatureError{Err: ErrSignatureMissing, Signer: name}).Error())
+	}
+	current, err := GetAccountPublicKey(stub, name, now.Format(TimeLayout))
+	if err != nil {
+		return shim.Error("RotateAccountKey(): " + err.Error())
+	}
+	message, _ := json.Marshal([]string{"iRotateAccountKey", name, publicKey, validFrom})
+	err = VerifySignature(name, current, message, signature)
+	if err != nil {
+		return shim.Error("RotateAccountKey(): " + err.Error())
+	}
+
+	key, err := AddAccountKey(stub, *acc, publicKey, validFrom, now)
+	if err != nil {
+		return shim.Error("RotateAccountKey(): " + err.Error())
+	}
+	acc.PublicKey = publicKey
+	response := ReplaceAccountInfoObj(stub, "AccountInfoObj", *acc)
+	if response.Status != shim.OK {
+		return shim.Error("RotateAccountKey(): " + response.Message)
+	}
+
+	buff, err := json.Marshal(key)
+	if err != nil {
+		return shim.Error("RotateAccountKey(): " + err.Error())
+	}
+	err = EmitObjectEvent(stub, EVENT_ACCOUNT_KEY_ROTATED, "AccountKeyObj", []string{key.Name, key.ValidFrom}, buff)
+	if err != nil {
+		return shim.Error("RotateAccountKey(): " + err.Error())
+	}
+	return shim.Success(buff)
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+// Get the key history of an account, oldest first
+// peer chaincode query -l golang -n test_trace -c '{"Function": "qGetAccountKeyHistory", "Args": ["Name"]}' -o orderer0:7050
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+func GetAccountKeyHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	acc, err := GetAccountInfoObj(stub, args[0])
+	if err != nil {
+		return shim.Error("GetAccountKeyHistory() operation failed. " + err.Error())
+	}
+	if acc == nil {
+		return NotFound("GetAccountKeyHistory(): AccountInfoObj " + args[0])
+	}
+	keys, err := LoadAccountKeys(stub, *acc)
+	if err != nil {
+		error_str := fmt.Sprintf("GetAccountKeyHistory() operation failed. %s", err)
+		fmt.Println(error_str)
+		return shim.Error(error_str)
+	}
+
+	buff, err := json.Marshal(keys)
+	if err != nil {
+		return shim.Error("GetAccountKeyHistory() operation failed - Marshall Error. " + err.Error())
+	}
+	return shim.Success(buff)
+}
+
+//////////////////////////////////////////////////////////
+// The AccountInfoObj name, nil if there is none
+//////////////////////////////////////////////////////////
+func GetAccountInfoObj(stub shim.ChaincodeStubInterface, name string) (*AccountInfoObj, error) {
+
+	Avalbytes, err := QueryObject(stub, "AccountInfoObj", []string{name})
+	if err != nil || Avalbytes == nil {
+		return nil, err
+	}
+	acc, err := JSONtoAccountInfoObj(Avalbytes)
+	if err != nil {
+		return nil, err
+	}
+	return &acc, nil
+}
+
+//////////////////////////////////////////////////////////
+// The keys of acc, oldest first. An account registered
+// before the history was kept has its PublicKey as only
+// key
+//////////////////////////////////////////////////////////
+func LoadAccountKeys(stub shim.ChaincodeStubInterface, acc AccountInfoObj) ([]AccountKeyObj, error) {
+
+	values, err := GetListValues(stub, "AccountKeyObj", []string{acc.Name})
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return []AccountKeyObj{{acc.Name, acc.PublicKey, FIRST_KEY_VALID_FROM, "", acc.TimeStamp}}, nil
+	}
+	keys := make([]AccountKeyObj, len(values))
+	for i, value := range values {
+		err = json.Unmarshal(value, &keys[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+//////////////////////////////////////////////////////////
+// The key valid at timeStamp, nil if there is none
+//////////////////////////////////////////////////////////
+func AccountKeyAt(keys []AccountKeyObj, timeStamp string) *AccountKeyObj {
+
+	var found *AccountKeyObj
+	for i := range keys {
+		if keys[i].ValidFrom <= timeStamp {
+			found = &keys[i]
+		}
+	}
+	return found
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Register publicKey for acc from validFrom on, closing the latest key.
+// validFrom must be after the ValidFrom of the latest key
+////////////////////////////////////////////////////////////////////////////
+func AddAccountKey(stub shim.ChaincodeStubInterface, acc AccountInfoObj, publicKey string, validFrom string, now time.Time) (AccountKeyObj, error) {
+
+	key := AccountKeyObj{acc.Name, publicKey, validFrom, "", now.Format(TimeLayout)}
+
+	keys, err := LoadAccountKeys(stub, acc)
+	if err != nil {
+		return key, err
+	}
+	latest := keys[len(keys)-1]
+	if validFrom <= latest.ValidFrom {
+		return key, errors.New("ValidFrom " + validFrom + " must be after " + latest.ValidFrom + ", the ValidFrom of the latest key")
+	}
+	latest.ValidUntil = validFrom
+	err = PutAccountKey(stub, latest)
+	if err != nil {
+		return key, err
+	}
+	return key, PutAccountKey(stub, key)
+}
+
+func PutAccountKey(stub shim.ChaincodeStubInterface, key AccountKeyObj) error {
+
+	buff, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return ReplaceObject(stub, "AccountKeyObj", []string{key.Name, key.ValidFrom}, buff)
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Keep the key history of an account written by iPostAccountInfo or
+// iUpdateAccountInfo. previous is nil for a new account, whose PublicKey
+// is its first key. A registered account keeps its PublicKey, a change is
+// not signed and goes through iRotateAccountKey
+////////////////////////////////////////////////////////////////////////////
+func RecordAccountKey(stub shim.ChaincodeStubInterface, previous *AccountInfoObj, acc AccountInfoObj) error {
+
+	now, err := GetTxTime(stub)
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		return PutAccountKey(stub, AccountKeyObj{acc.Name, acc.PublicKey, FIRST_KEY_VALID_FROM, "", now.Format(TimeLayout)})
+	}
+	if previous.PublicKey != acc.PublicKey {
+		return &SignatureError{Err: ErrKeyChangeNotSigned, Signer: acc.Name, Detail: "change the PublicKey with iRotateAccountKey"}
+	}
+	return nil
+}
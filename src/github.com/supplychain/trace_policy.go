@@ -0,0 +1,376 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+///////////////////////////////////////////////////////////////////////////////////////
+//
+// Access policy
+//
+// The policy maps the identity of the submitter (MSP ID and certificate attributes)
+// to the i*/q* functions it may call, and to the OrgName/AccountType of the accounts
+// whose records it may write. A record belongs to the account that signs it:
+//
+//              "AccountInfoObj":                         the record itself
+//              "CertificationAccountInfoObj":            the record itself
+//              "SkuTraceRecordObj":                      AccountInfoObj of AddressHash
+//              "SkuBaseInfoObj":                         AccountInfoObj of AddressHash
+//              "SkuTransactionObj":                      AccountInfoObj of AccountNo
+//              "SkuAuthenticationTraceRecordObj":        CertificationAccountInfoObj of CertificationBodyName
+//
+// The first policy is set at Init, and only an admin of the configuration, see
+// trace_config.go, may replace it with iSetAccessPolicy. While no policy is on the
+// ledger every function is open to every submitter
+// peer chaincode invoke -n test_trace -c '{"Function": "iSetAccessPolicy", "Args":["{\"Rules\":[{\"MspId\":\"FarmMSP\",
+// \"Functions\":[\"iPostSkuTraceRecord\"],\"OrgNames\":[\"Farm\"],\"AccountTypes\":[\"Farm\"]}]}"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////
+
+type AccessRule struct {
+	MspId        string            // MSP ID of the submitter, "*" for any
+	Attributes   map[string]string // Certificate attributes the submitter must carry
+	Functions    []string          // Functions the submitter may call, "*" for all
+	OrgNames     []string          // OrgName of the accounts it may write records for, empty for any
+	AccountTypes []string          // AccountType of the accounts it may write records for, empty for any
+}
+
+type AccessPolicyObj struct {
+	Rules     []AccessRule
+	TimeStamp string // This is the time stamp
+}
+
+// The AccessPolicyObj is a single record kept under this key
+const ACCESS_POLICY_KEY = "AccessPolicy"
+
+// Certificate extension fabric-ca stores the enrollment attributes in
+var attributesOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+var ErrAccessDenied = errors.New("ACCESS_DENIED")
+
+//////////////////////////////////////////////////////////
+// The submitter of the transaction
+//////////////////////////////////////////////////////////
+type CallerIdentity struct {
+	MspId      string
+	CommonName string
+	Attributes map[string]string
+}
+
+func (c CallerIdentity) String() string {
+	return c.MspId + "/" + c.CommonName
+}
+
+//////////////////////////////////////////////////////////
+// Resolves the OrgName and AccountType of the account
+// a record belongs to, the Owner of its ObjectType
+//////////////////////////////////////////////////////////
+func RecordOwner(objectType string) RecordOwnerFunc {
+
+	registered := GetObjectType(objectType)
+	if registered == nil {
+		return nil
+	}
+	return registered.Owner
+}
+
+func AccountInfoOwner(stub shim.ChaincodeStubInterface, objectData []byte) (string, string, error) {
+	acc, err := JSONtoAccountInfoObj(objectData)
+	return acc.OrgName, acc.AccountType, err
+}
+
+func CertificationAccountInfoOwner(stub shim.ChaincodeStubInterface, objectData []byte) (string, string, error) {
+	acc, err := JSONtoCertificationAccountInfoObj(objectData)
+	return acc.OrgName, acc.AccountType, err
+}
+
+func SkuTraceRecordOwner(stub shim.ChaincodeStubInterface, objectData []byte) (string, string, error) {
+	rec, err := JSONtoSkuTraceRecordObj(objectData)
+	if err != nil {
+		return "", "", err
+	}
+	return accountOwner(stub, "AccountInfoObj", rec.AddressHash)
+}
+
+func SkuBaseInfoOwner(stub shim.ChaincodeStubInterface, objectData []byte) (string, string, error) {
+	rec, err := JSONtoSkuBaseInfoObj(objectData)
+	if err != nil {
+		return "", "", err
+	}
+	return accountOwner(stub, "AccountInfoObj", rec.AddressHash)
+}
+
+func SkuTransactionOwner(stub shim.ChaincodeStubInterface, objectData []byte) (string, string, error) {
+	rec, err := JSONtoSkuTransactionObj(objectData)
+	if err != nil {
+		return "", "", err
+	}
+	return accountOwner(stub, "AccountInfoObj", rec.AccountNo)
+}
+
+func SkuAuthenticationTraceRecordOwner(stub shim.ChaincodeStubInterface, objectData []byte) (string, string, error) {
+	rec, err := JSONtoSkuAuthenticationTraceRecordObj(objectData)
+	if err != nil {
+		return "", "", err
+	}
+	return accountOwner(stub, "CertificationAccountInfoObj", rec.CertificationBodyName)
+}
+
+func accountOwner(stub shim.ChaincodeStubInterface, objectType string, name string) (string, string, error) {
+
+	Avalbytes, err := QueryObject(stub, objectType, []string{name})
+	if err != nil {
+		return "", "", err
+	}
+	if Avalbytes == nil {
+		return "", "", fmt.Errorf("%s : %s %s is not registered", ErrAccessDenied, objectType, name)
+	}
+	return RecordOwner(objectType)(stub, Avalbytes)
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Read the MSP ID, common name and fabric-ca attributes of the submitter
+////////////////////////////////////////////////////////////////////////////
+func GetCallerIdentity(stub shim.ChaincodeStubInterface) (CallerIdentity, error) {
+
+	var caller CallerIdentity
+	creator, err := stub.GetCreator()
+	if err != nil {
+		return caller, err
+	}
+
+	sid := &msp.SerializedIdentity{}
+	err = proto.Unmarshal(creator, sid)
+	if err != nil {
+		return caller, errors.New("GetCallerIdentity() : Failed to read the submitter identity : " + err.Error())
+	}
+	caller.MspId = sid.Mspid
+	caller.Attributes = map[string]string{}
+
+	block, _ := pem.Decode(sid.IdBytes)
+	if block == nil {
+		return caller, nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return caller, errors.New("GetCallerIdentity() : Failed to parse the submitter certificate : " + err.Error())
+	}
+	caller.CommonName = cert.Subject.CommonName
+
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(attributesOID) {
+			continue
+		}
+		var attrs struct {
+			Attrs map[string]string `json:"attrs"`
+		}
+		if json.Unmarshal(ext.Value, &attrs) == nil && attrs.Attrs != nil {
+			caller.Attributes = attrs.Attrs
+		}
+	}
+	return caller, nil
+}
+
+//////////////////////////////////////////////////////////
+// Load the AccessPolicyObj, nil if none is set
+//////////////////////////////////////////////////////////
+func GetAccessPolicy(stub shim.ChaincodeStubInterface) (*AccessPolicyObj, error) {
+
+	Avalbytes, err := QueryObject(stub, "AccessPolicyObj", []string{ACCESS_POLICY_KEY})
+	if err != nil {
+		return nil, err
+	}
+	if Avalbytes == nil {
+		return nil, nil
+	}
+	policy := &AccessPolicyObj{}
+	err = json.Unmarshal(Avalbytes, policy)
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (r AccessRule) matches(caller CallerIdentity) bool {
+	if r.MspId != "*" && r.MspId != caller.MspId {
+		return false
+	}
+	for name, value := range r.Attributes {
+		if caller.Attributes[name] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func (r AccessRule) allows(function string) bool {
+	return containsOrEmpty(r.Functions, function, false)
+}
+
+func containsOrEmpty(list []string, value string, emptyMatches bool) bool {
+	if len(list) == 0 {
+		return emptyMatches
+	}
+	for _, v := range list {
+		if v == "*" || v == value {
+			return true
+		}
+	}
+	return false
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Check the submitter may call function
+////////////////////////////////////////////////////////////////////////////
+func AuthorizeFunction(stub shim.ChaincodeStubInterface, function string) error {
+
+	policy, err := GetAccessPolicy(stub)
+	if err != nil || policy == nil {
+		return err
+	}
+	caller, err := GetCallerIdentity(stub)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range policy.Rules {
+		if rule.matches(caller) && rule.allows(function) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s : %s may not call %s", ErrAccessDenied, caller, function)
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Check the submitter may write objectData. Both the record being written
+// and, for a replacement, the record on the ledger must belong to an
+// account the submitter may write for
+////////////////////////////////////////////////////////////////////////////
+func AuthorizeObjectWrite(stub shim.ChaincodeStubInterface, objectType string, compositeKey string, objectData []byte) error {
+
+	ownerFunc := RecordOwner(objectType)
+	if ownerFunc == nil {
+		return nil
+	}
+	policy, err := GetAccessPolicy(stub)
+	if err != nil || policy == nil {
+		return err
+	}
+	caller, err := GetCallerIdentity(stub)
+	if err != nil {
+		return err
+	}
+	function, _ := stub.GetFunctionAndParameters()
+
+	records := [][]byte{objectData}
+	current, err := stub.GetState(compositeKey)
+	if err != nil {
+		return err
+	}
+	if current != nil && !IsTombstone(current) {
+		records = append(records, current)
+	}
+
+	for _, record := range records {
+		orgName, accountType, err := ownerFunc(stub, record)
+		if err != nil {
+			return err
+		}
+		allowed := false
+		for _, rule := range policy.Rules {
+			if rule.matches(caller) && rule.allows(function) &&
+				containsOrEmpty(rule.OrgNames, orgName, true) && containsOrEmpty(rule.AccountTypes, accountType, true) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%s : %s may not write %s records of %s/%s", ErrAccessDenied, caller, objectType, orgName, accountType)
+		}
+	}
+	return nil
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Replace the AccessPolicyObj, only an admin may and only once Init set one
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iSetAccessPolicy", "Args":["{\"Rules\":[...]}"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func SetAccessPolicy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 1 {
+		return shim.Error("SetAccessPolicy(): Incorrect number of arguments. Expecting 1")
+	}
+	_, err := AuthorizeAdmin(stub)
+	if err != nil {
+		return shim.Error("SetAccessPolicy(): " + err.Error())
+	}
+	stored, err := GetAccessPolicy(stub)
+	if err != nil {
+		return shim.Error("SetAccessPolicy(): " + err.Error())
+	}
+	if stored == nil {
+		return shim.Error(fmt.Sprintf("SetAccessPolicy(): %s : the first access policy is set at Init", ErrAccessDenied))
+	}
+	buff, err := StoreAccessPolicy(stub, []byte(args[0]))
+	if err != nil {
+		return shim.Error("SetAccessPolicy(): " + err.Error())
+	}
+	err = EmitObjectEvent(stub, EVENT_ACCESS_POLICY_SET, "AccessPolicyObj", []string{ACCESS_POLICY_KEY}, buff)
+	if err != nil {
+		return shim.Error("SetAccessPolicy(): " + err.Error())
+	}
+	return shim.Success(buff)
+}
+
+//////////////////////////////////////////////////////////
+// Write the AccessPolicyObj, the caller emits the event
+//////////////////////////////////////////////////////////
+func StoreAccessPolicy(stub shim.ChaincodeStubInterface, objectData []byte) ([]byte, error) {
+
+	var policy AccessPolicyObj
+	err := JSONtoObject("AccessPolicyObj", objectData, &policy, []string{"Rules"})
+	if err != nil {
+		return nil, err
+	}
+	for i, rule := range policy.Rules {
+		if rule.MspId == "" || len(rule.Functions) == 0 {
+			return nil, fmt.Errorf("StoreAccessPolicy() : rule %d must name an MspId and at least one function", i)
+		}
+	}
+	now, err := GetTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	policy.TimeStamp = now.Format(TimeLayout)
+
+	buff, err := json.Marshal(policy)
+	if err != nil {
+		return nil, err
+	}
+	err = ReplaceObject(stub, "AccessPolicyObj", []string{ACCESS_POLICY_KEY}, buff)
+	if err != nil {
+		return nil, err
+	}
+	return buff, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+// Retrieve the AccessPolicyObj
+// peer chaincode query -n test_trace -c '{"Function": "qGetAccessPolicy","Args":[]}' -o orderer0:7050
+//////////////////////////////////////////////////////////////////////////////////////////
+func GetAccessPolicyInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	Avalbytes, err := QueryObject(stub, "AccessPolicyObj", []string{ACCESS_POLICY_KEY})
+	if err != nil {
+		return shim.Error("GetAccessPolicyInfo(): " + err.Error())
+	}
+	return shim.Success(Avalbytes)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+///////////////////////////////////////////////////////////////////////////////////////
+//
+// Rich queries over SkuTraceRecordObj
+//
+// Needs CouchDB as the peer state database. A query is a restricted Mango query
+//
+//     {"selector":{"StationType":"Factory","BeginTime":{"$gte":"2017-06-01 00:00:00"}},
+//      "sort":[{"BeginTime":"desc"}],"limit":100}
+//
+// selector : conditions on the attributes in SkuTraceRecordQueryFields, all of which
+//            must hold. An attribute is matched by value or by an object of
+//            $eq, $gt, $gte, $lt, $lte or $in conditions. Conditions can be grouped in $and
+// sort     : attributes to sort on, "asc" or "desc". CouchDB only sorts on an index
+// limit    : at most MAX_PAGE_SIZE records, which is also the default
+//
+// The indexes under META-INF/statedb/couchdb/indexes are deployed with the chaincode
+///////////////////////////////////////////////////////////////////////////////////////
+
+// Attributes a SkuTraceRecordObj query can select and sort on
+var SkuTraceRecordQueryFields = map[string]bool{
+	"StationType": true,
+	"BatchNum":    true,
+	"StationName": true,
+	"BeginTime":   true,
+	"EndTime":     true,
+	"TraceCode":   true,
+	"SkuId":       true,
+}
+
+// Operators a selector condition can use
+var QueryOperators = map[string]bool{
+	"$eq":  true,
+	"$gt":  true,
+	"$gte": true,
+	"$lt":  true,
+	"$lte": true,
+	"$in":  true,
+}
+
+//////////////////////////////////////////////////////////
+// A Mango query as it is sent to CouchDB
+//////////////////////////////////////////////////////////
+type RichQuery struct {
+	Selector map[string]interface{} `json:"selector"`
+	Sort     []map[string]string    `json:"sort,omitempty"`
+	Limit    int                    `json:"limit,omitempty"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+// Query SKU trace records across all TraceCodes
+// peer chaincode query -l golang -n test_trace -c '{"Function": "qQuerySkuTraceRecords", "Args": ["{\"selector\":{\"StationType\":\"Factory\"},\"sort\":[{\"BeginTime\":\"asc\"}],\"limit\":100}"]}' -o orderer0:7050
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+func QuerySkuTraceRecords(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	query, err := BuildSkuTraceRecordQuery([]byte(args[0]))
+	if err != nil {
+		error_str := fmt.Sprintf("QuerySkuTraceRecords() operation failed. %s", err)
+		fmt.Println(error_str)
+		return shim.Error(error_str)
+	}
+	fmt.Println("QuerySkuTraceRecords() : query : ", query)
+
+	values, err := GetQueryResultValues(stub, query)
+	if err != nil {
+		error_str := fmt.Sprintf("QuerySkuTraceRecords() operation failed. %s", err)
+		fmt.Println(error_str)
+		return shim.Error(error_str)
+	}
+
+	var tlist []SkuTraceRecordObj
+	for _, value := range values {
+		bid, err := JSONtoSkuTraceRecordObj(value)
+		if err != nil {
+			error_str := fmt.Sprintf("QuerySkuTraceRecords() operation failed - Unmarshall Error. %s", err)
+			fmt.Println(error_str)
+			return shim.Error(error_str)
+		}
+		tlist = append(tlist, bid)
+	}
+
+	jsonRows, err := json.Marshal(tlist)
+	if err != nil {
+		error_str := fmt.Sprintf("QuerySkuTraceRecords() operation failed - Marshall Error. %s", err)
+		fmt.Println(error_str)
+		return shim.Error(error_str)
+	}
+	return shim.Success(jsonRows)
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Validate a client query and turn it into the Mango query sent to CouchDB
+// Only SkuTraceRecordObj documents carry a StationType, so requiring it
+// keeps the other Objects out of the result
+////////////////////////////////////////////////////////////////////////////
+func BuildSkuTraceRecordQuery(request []byte) (string, error) {
+
+	var query RichQuery
+	err := CheckJSONFields(&query, request)
+	if err == nil {
+		err = json.Unmarshal(request, &query)
+	}
+	if err != nil {
+		return "", errors.New("Invalid query : " + err.Error())
+	}
+
+	if len(query.Selector) == 0 {
+		return "", errors.New("Invalid query : selector is required")
+	}
+	err = ValidateSelector(query.Selector, SkuTraceRecordQueryFields)
+	if err != nil {
+		return "", err
+	}
+
+	for _, sort := range query.Sort {
+		if len(sort) != 1 {
+			return "", errors.New("Invalid query : each sort entry names one attribute")
+		}
+		for field, direction := range sort {
+			if !SkuTraceRecordQueryFields[field] {
+				return "", errors.New("Invalid query : can not sort on " + field)
+			}
+			if direction != "asc" && direction != "desc" {
+				return "", errors.New("Invalid query : sort direction must be asc or desc")
+			}
+		}
+	}
+
+	if query.Limit < 0 || query.Limit > MAX_PAGE_SIZE {
+		return "", fmt.Errorf("Invalid query : limit must be between 1 and %d", MAX_PAGE_SIZE)
+	}
+	if query.Limit == 0 {
+		query.Limit = MAX_PAGE_SIZE
+	}
+
+	query.Selector = map[string]interface{}{
+		"$and": []interface{}{
+			map[string]interface{}{"StationType": map[string]interface{}{"$exists": true}},
+			query.Selector,
+		},
+	}
+
+	buff, err := json.Marshal(query)
+	if err != nil {
+		return "", err
+	}
+	return string(buff), nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Check that a selector only uses the allowed attributes and operators
+// All attributes are strings, so are the values compared against
+////////////////////////////////////////////////////////////////////////////
+func ValidateSelector(selector map[string]interface{}, fields map[string]bool) error {
+
+	for field, condition := range selector {
+		if field == "$and" {
+			list, ok := condition.([]interface{})
+			if !ok || len(list) == 0 {
+				return errors.New("Invalid query : $and takes a list of selectors")
+			}
+			for _, item := range list {
+				sub, ok := item.(map[string]interface{})
+				if !ok {
+					return errors.New("Invalid query : $and takes a list of selectors")
+				}
+				err := ValidateSelector(sub, fields)
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if !fields[field] {
+			return errors.New("Invalid query : can not select on " + field)
+		}
+
+		switch c := condition.(type) {
+		case string:
+		case map[string]interface{}:
+			if len(c) == 0 {
+				return errors.New("Invalid query : empty condition on " + field)
+			}
+			for op, value := range c {
+				if !QueryOperators[op] {
+					return errors.New("Invalid query : operator " + op + " is not allowed")
+				}
+				if op == "$in" {
+					list, ok := value.([]interface{})
+					if !ok || len(list) == 0 {
+						return errors.New("Invalid query : $in on " + field + " takes a list of strings")
+					}
+					for _, v := range list {
+						if _, ok := v.(string); !ok {
+							return errors.New("Invalid query : $in on " + field + " takes a list of strings")
+						}
+					}
+				} else if _, ok := value.(string); !ok {
+					return errors.New("Invalid query : " + op + " on " + field + " takes a string")
+				}
+			}
+		default:
+			return errors.New("Invalid query : condition on " + field + " must be a string or an object of operators")
+		}
+	}
+	return nil
+}
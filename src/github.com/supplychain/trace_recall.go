@@ -0,0 +1,278 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+///////////////////////////////////////////////////////////////////////////////////////
+//
+// Recalls
+//
+// A recall covers either a batch, SkuId and BatchNum, or an explicit list of
+// TraceCodes. A TraceCode is recalled while an ACTIVE recall covers it, directly
+// or through the SkuId and BatchNum of its SkuBaseInfoObj.
+//
+// Each recall is indexed so it can be found from what it covers
+//     RecallBatchObj     : SkuId, BatchNum, RecallId
+//     RecallTraceCodeObj : TraceCode, RecallId
+///////////////////////////////////////////////////////////////////////////////////////
+
+const (
+	RECALL_ACTIVE = "ACTIVE"
+	RECALL_CLOSED = "CLOSED"
+)
+
+type RecallObj struct {
+	RecallId    string
+	SkuId       string
+	BatchNum    string
+	TraceCodes  []string
+	Reason      string
+	IssuedBy    string
+	Status      string // ACTIVE or CLOSED, set by the chaincode
+	IssuedAt    string // Transaction time of iPostRecall
+	ClosedAt    string // Transaction time of iCloseRecall
+	CloseReason string
+}
+
+//////////////////////////////////////////////////////////
+// Index entry pointing at a RecallObj
+//////////////////////////////////////////////////////////
+type RecallIndexObj struct {
+	RecallId string
+}
+
+//////////////////////////////////////////////////////////
+// Recall status of a TraceCode at the point of scan
+//////////////////////////////////////////////////////////
+type RecallStatusObj struct {
+	TraceCode string
+	SkuId     string
+	BatchNum  string
+	Recalled  bool
+	Recalls   []RecallObj // The ACTIVE recalls covering the TraceCode
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Issue a recall for a batch
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iPostRecall", "Args":["{\"RecallId\":\"R1\",\"SkuId\":\"SkuId\",
+// \"BatchNum\":\"BatchNum\",\"Reason\":\"Reason\",\"IssuedBy\":\"IssuedBy\"}"]}' -o orderer0:7050
+// or for a list of TraceCodes
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iPostRecall", "Args":["{\"RecallId\":\"R2\",
+// \"TraceCodes\":[\"1111\",\"1112\"],\"Reason\":\"Reason\",\"IssuedBy\":\"IssuedBy\"}"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func PostRecall(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 1 {
+		return shim.Error("PostRecall(): Incorrect number of arguments. Expecting 1")
+	}
+	var recall RecallObj
+	err := JSONtoObject("RecallObj", []byte(args[0]), &recall, RequiredFields("RecallObj"))
+	if err != nil {
+		return shim.Error("PostRecall(): " + err.Error())
+	}
+	err = ValidateRecallScope(recall)
+	if err != nil {
+		return shim.Error("PostRecall(): " + err.Error())
+	}
+
+	Avalbytes, err := QueryObject(stub, "RecallObj", []string{recall.RecallId})
+	if err != nil {
+		return shim.Error("PostRecall(): " + err.Error())
+	}
+	if Avalbytes != nil {
+		return Conflict("PostRecall(): RecallObj " + recall.RecallId + " already exists")
+	}
+
+	now, err := GetTxTime(stub)
+	if err != nil {
+		return shim.Error("PostRecall(): " + err.Error())
+	}
+	recall.Status = RECALL_ACTIVE
+	recall.IssuedAt = now.Format(TimeLayout)
+	recall.ClosedAt = ""
+	recall.CloseReason = ""
+
+	buff, err := json.Marshal(recall)
+	if err != nil {
+		return shim.Error("PostRecall(): " + err.Error())
+	}
+	keys := []string{recall.RecallId}
+	err = UpdateObject(stub, "RecallObj", keys, buff)
+	if err != nil {
+		fmt.Println("PostRecall() : write error while inserting record")
+		return shim.Error("PostRecall() : write error while inserting record : Error - " + err.Error())
+	}
+
+	index, _ := json.Marshal(RecallIndexObj{recall.RecallId})
+	if recall.BatchNum != "" {
+		err = UpdateObject(stub, "RecallBatchObj", []string{recall.SkuId, recall.BatchNum, recall.RecallId}, index)
+		if err != nil {
+			return shim.Error("PostRecall() : write error while indexing record : Error - " + err.Error())
+		}
+	}
+	for _, traceCode := range recall.TraceCodes {
+		err = UpdateObject(stub, "RecallTraceCodeObj", []string{traceCode, recall.RecallId}, index)
+		if err != nil {
+			return shim.Error("PostRecall() : write error while indexing record : Error - " + err.Error())
+		}
+	}
+
+	err = EmitObjectEvent(stub, EVENT_RECALL_POSTED, "RecallObj", keys, buff)
+	if err != nil {
+		return shim.Error("PostRecall() : " + err.Error())
+	}
+	return shim.Success(buff)
+}
+
+//////////////////////////////////////////////////////////
+// A recall covers a batch or a list of TraceCodes
+//////////////////////////////////////////////////////////
+func ValidateRecallScope(recall RecallObj) error {
+
+	batch := recall.SkuId != "" || recall.BatchNum != ""
+	if batch && len(recall.TraceCodes) > 0 {
+		return errors.New("a recall covers either SkuId and BatchNum or TraceCodes, not both")
+	}
+	if batch && (recall.SkuId == "" || recall.BatchNum == "") {
+		return errors.New("a batch recall needs both SkuId and BatchNum")
+	}
+	if !batch && len(recall.TraceCodes) == 0 {
+		return errors.New("a recall needs SkuId and BatchNum or TraceCodes")
+	}
+	for _, traceCode := range recall.TraceCodes {
+		if traceCode == "" {
+			return errors.New("TraceCodes can not be empty")
+		}
+	}
+	return nil
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Close a recall
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iCloseRecall", "Args":["RecallId", "CloseReason"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func CloseRecall(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 2 {
+		return shim.Error("CloseRecall(): Incorrect number of arguments. Expecting RecallId and CloseReason")
+	}
+	keys := []string{args[0]}
+
+	Avalbytes, err := QueryObject(stub, "RecallObj", keys)
+	if err != nil {
+		return shim.Error("CloseRecall(): " + err.Error())
+	}
+	if Avalbytes == nil {
+		return NotFound("CloseRecall(): RecallObj " + args[0])
+	}
+	var recall RecallObj
+	err = json.Unmarshal(Avalbytes, &recall)
+	if err != nil {
+		return shim.Error("CloseRecall(): Object UnMarshalling Failed ")
+	}
+	if recall.Status != RECALL_ACTIVE {
+		return Conflict("CloseRecall(): RecallObj " + args[0] + " is already " + recall.Status)
+	}
+
+	now, err := GetTxTime(stub)
+	if err != nil {
+		return shim.Error("CloseRecall(): " + err.Error())
+	}
+	recall.Status = RECALL_CLOSED
+	recall.ClosedAt = now.Format(TimeLayout)
+	recall.CloseReason = args[1]
+
+	buff, err := json.Marshal(recall)
+	if err != nil {
+		return shim.Error("CloseRecall(): " + err.Error())
+	}
+	err = ReplaceObject(stub, "RecallObj", keys, buff)
+	if err != nil {
+		fmt.Println("CloseRecall() : write error while inserting record")
+		return shim.Error("CloseRecall(): " + err.Error())
+	}
+	err = EmitObjectEvent(stub, EVENT_RECALL_CLOSED, "RecallObj", keys, buff)
+	if err != nil {
+		return shim.Error("CloseRecall(): " + err.Error())
+	}
+	return shim.Success(buff)
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+// Get the recall status of a TraceCode
+// peer chaincode query -l golang -n test_trace -c '{"Function": "qGetRecallStatusByTraceCode", "Args": ["1111"]}' -o orderer0:7050
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+func GetRecallStatusByTraceCode(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	status, err := GetRecallStatus(stub, args[0])
+	if err != nil {
+		error_str := fmt.Sprintf("GetRecallStatusByTraceCode() operation failed. %s", err)
+		fmt.Println(error_str)
+		return shim.Error(error_str)
+	}
+	buff, err := json.Marshal(status)
+	if err != nil {
+		return shim.Error("GetRecallStatusByTraceCode() operation failed - Marshall Error. " + err.Error())
+	}
+	return shim.Success(buff)
+}
+
+//////////////////////////////////////////////////////////
+// The ACTIVE recalls covering traceCode
+//////////////////////////////////////////////////////////
+func GetRecallStatus(stub shim.ChaincodeStubInterface, traceCode string) (RecallStatusObj, error) {
+
+	status := RecallStatusObj{TraceCode: traceCode}
+
+	values, err := GetListValues(stub, "RecallTraceCodeObj", []string{traceCode})
+	if err != nil {
+		return status, err
+	}
+
+	baseInfo, err := LoadSkuBaseInfo(stub, traceCode)
+	if err != nil {
+		return status, err
+	}
+	if baseInfo != nil && baseInfo.SkuId != "" && baseInfo.BatchNum != "" {
+		status.SkuId = baseInfo.SkuId
+		status.BatchNum = baseInfo.BatchNum
+		batch, err := GetListValues(stub, "RecallBatchObj", []string{baseInfo.SkuId, baseInfo.BatchNum})
+		if err != nil {
+			return status, err
+		}
+		values = append(values, batch...)
+	}
+
+	for _, value := range values {
+		var index RecallIndexObj
+		err = json.Unmarshal(value, &index)
+		if err != nil {
+			return status, err
+		}
+		Avalbytes, err := QueryObject(stub, "RecallObj", []string{index.RecallId})
+		if err != nil {
+			return status, err
+		}
+		if Avalbytes == nil {
+			continue
+		}
+		var recall RecallObj
+		err = json.Unmarshal(Avalbytes, &recall)
+		if err != nil {
+			return status, err
+		}
+		if recall.Status == RECALL_ACTIVE {
+			status.Recalls = append(status.Recalls, recall)
+		}
+	}
+	status.Recalled = len(status.Recalls) > 0
+	return status, nil
+}
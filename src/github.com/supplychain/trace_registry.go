@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////////////
+//
+// Generated chaincode functions
+//
+// The Functions of an ObjectType in table_1.0api.go name the chaincode functions
+// generated for it, resolved by InvokeFunction and QueryFunction when they are not
+// in their maps
+//     POST    : the Object, as a JSON document or its attributes in order
+//     REPLACE : the same, replacing a stored Object
+//     PATCH   : the keys of a stored Object and a JSON patch of the attributes to change
+//     GET     : the keys of an Object, nothing if there is none
+//     LIST    : TraceCode [, pageSize [, bookmark]] [, includeDeleted], see GetListByTraceCode
+// POST, REPLACE and PATCH run the Validators of the type and its BeforePost or
+// BeforeUpdate hook, write the Object and emit the event of the function
+///////////////////////////////////////////////////////////////////////////////////////
+
+const (
+	OBJECT_POST    = "POST"
+	OBJECT_REPLACE = "REPLACE"
+	OBJECT_PATCH   = "PATCH"
+	OBJECT_GET     = "GET"
+	OBJECT_LIST    = "LIST"
+)
+
+//////////////////////////////////////////////////////////////
+// The function generated as fname, nil if there is none
+//////////////////////////////////////////////////////////////
+func ObjectFunctionHandler(fname string) func(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	for _, objectType := range ObjectTypes() {
+		function, ok := objectType.Functions[fname]
+		if !ok {
+			continue
+		}
+		// Messages name the function without its i or q, eg: PostSkuBaseInfo()
+		caller := fname[1:]
+		switch function.Kind {
+		case OBJECT_POST:
+			return func(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+				return PostObjectRecord(stub, caller, objectType, function.Event, args)
+			}
+		case OBJECT_REPLACE:
+			return func(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+				return ReplaceObjectRecord(stub, caller, objectType, function.Event, args)
+			}
+		case OBJECT_PATCH:
+			return func(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+				return PatchObjectRecord(stub, caller, objectType, function.Event, args)
+			}
+		case OBJECT_GET:
+			return func(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+				return GetObjectRecord(stub, caller, objectType, args)
+			}
+		case OBJECT_LIST:
+			return func(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+				return ListObjectRecords(stub, caller, objectType, args)
+			}
+		}
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Write an Object, new or replacing the stored one
+////////////////////////////////////////////////////////////////////////////
+func PostObjectRecord(stub shim.ChaincodeStubInterface, caller string, objectType *ObjectType, event string, args []string) pb.Response {
+
+	object, err := ObjectFromArgs(objectType, args)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	keys := ObjectKeys(objectType, object)
+	previous, err := LoadObject(stub, objectType, keys)
+	if err != nil {
+		return shim.Error(caller + "() : " + err.Error())
+	}
+	KeepChaincodeFields(objectType, previous, object)
+
+	return WriteObjectRecord(stub, caller, objectType, event, keys, previous, object, objectType.BeforePost, UpdateObject)
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Replace a stored Object with the one in args
+////////////////////////////////////////////////////////////////////////////
+func ReplaceObjectRecord(stub shim.ChaincodeStubInterface, caller string, objectType *ObjectType, event string, args []string) pb.Response {
+
+	object, err := ObjectFromArgs(objectType, args)
+	if err != nil {
+		return shim.Error(caller + "(): " + err.Error())
+	}
+	keys := ObjectKeys(objectType, object)
+	previous, err := LoadObject(stub, objectType, keys)
+	if err != nil {
+		fmt.Println(caller + "(): Object Retrieval Failed ")
+		return shim.Error(caller + "(): Object Retrieval Failed : " + err.Error())
+	}
+	if previous == nil {
+		return NotFound(caller + "(): " + objectType.Name + " " + strings.Join(keys, ","))
+	}
+	KeepChaincodeFields(objectType, previous, object)
+
+	return WriteObjectRecord(stub, caller, objectType, event, keys, previous, object, objectType.BeforeUpdate, ReplaceObject)
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Apply a JSON patch to a stored Object, args are its keys and the patch
+////////////////////////////////////////////////////////////////////////////
+func PatchObjectRecord(stub shim.ChaincodeStubInterface, caller string, objectType *ObjectType, event string, args []string) pb.Response {
+
+	nKeys := GetNumberOfKeys(objectType.Name)
+	if len(args) != nKeys+1 {
+		return shim.Error(caller + "(): Incorrect number of arguments. Expecting " + strings.Join(objectType.KeyFields, ", ") + " and a JSON patch")
+	}
+	keys := args[0:nKeys]
+
+	previous, err := LoadObject(stub, objectType, keys)
+	if err != nil {
+		fmt.Println(caller + "(): Object Retrieval Failed ")
+		return shim.Error(caller + "(): Object Retrieval Failed : " + err.Error())
+	}
+	if previous == nil {
+		return NotFound(caller + "(): " + objectType.Name + " " + strings.Join(keys, ","))
+	}
+
+	// Patch a copy, the hooks get the stored Object as it was
+	object := objectType.New()
+	buff, _ := json.Marshal(previous)
+	json.Unmarshal(buff, object)
+	err = PatchObject(objectType.Name, object, []byte(args[nKeys]), objectType.KeyFields)
+	if err != nil {
+		return shim.Error(caller + "(): " + err.Error())
+	}
+	KeepChaincodeFields(objectType, previous, object)
+
+	return WriteObjectRecord(stub, caller, objectType, event, keys, previous, object, objectType.BeforeUpdate, ReplaceObject)
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Validate object, run hook and write it with write, either UpdateObject
+// or ReplaceObject
+////////////////////////////////////////////////////////////////////////////
+func WriteObjectRecord(stub shim.ChaincodeStubInterface, caller string, objectType *ObjectType, event string, keys []string,
+	previous interface{}, object interface{}, hook ObjectHook,
+	write func(stub shim.ChaincodeStubInterface, objectType string, keys []string, objectData []byte) error) pb.Response {
+
+	for _, validate := range objectType.Validators {
+		err := validate(stub, object)
+		if err != nil {
+			fmt.Println(caller+"() : verification failed : ", err)
+			return shim.Error(caller + "() : " + err.Error())
+		}
+	}
+	if hook != nil {
+		err := hook(stub, previous, object)
+		if err == ErrObjectExists {
+			return Conflict(caller + "() : " + objectType.Name + " " + strings.Join(keys, ",") + " is already posted, update it instead")
+		}
+		if err != nil {
+			return shim.Error(caller + "() : " + err.Error())
+		}
+	}
+
+	buff, err := json.Marshal(object)
+	if err != nil {
+		error_str := caller + "() : Failed Cannot create object buffer for write : " + strings.Join(keys, ",")
+		fmt.Println(error_str)
+		return shim.Error(error_str)
+	}
+	err = write(stub, objectType.Name, keys, buff)
+	if err != nil {
+		fmt.Println(caller + "() : write error while inserting record")
+		return shim.Error(caller + "() : write error while inserting record : Error - " + err.Error())
+	}
+	err = EmitObjectEvent(stub, event, objectType.Name, keys, buff)
+	if err != nil {
+		return shim.Error(caller + "() : " + err.Error())
+	}
+	return shim.Success(buff)
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Retrieve an Object by its keys, nothing if there is none
+////////////////////////////////////////////////////////////////////////////
+func GetObjectRecord(stub shim.ChaincodeStubInterface, caller string, objectType *ObjectType, args []string) pb.Response {
+
+	if len(args) != GetNumberOfKeys(objectType.Name) {
+		return shim.Error(fmt.Sprintf("Incorrect number of arguments. Expecting %d", GetNumberOfKeys(objectType.Name)))
+	}
+	Avalbytes, err := QueryObject(stub, objectType.Name, args)
+	if err != nil {
+		fmt.Println(caller + "() : Failed to Query Object ")
+		jsonResp := "{\"Error\":\"Failed to get  Object Data for " + strings.Join(args, ",") + "\"}"
+		return shim.Error(jsonResp)
+	}
+	if Avalbytes == nil {
+		return shim.Success(nil)
+	}
+
+	fmt.Println(caller + "() : Response : Successfull -")
+	return shim.Success(Avalbytes)
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Retrieve the Objects of a TraceCode, through the ListView of the type
+// when it has one
+////////////////////////////////////////////////////////////////////////////
+func ListObjectRecords(stub shim.ChaincodeStubInterface, caller string, objectType *ObjectType, args []string) pb.Response {
+
+	values, page, err := GetListByTraceCode(stub, objectType.Name, args)
+	if err != nil {
+		error_str := fmt.Sprintf("%s operation failed. %s", caller, err)
+		return shim.Error(error_str)
+	}
+
+	var objects []interface{}
+	for _, value := range values {
+		object := objectType.New()
+		err = json.Unmarshal(value, object)
+		if err != nil {
+			error_str := fmt.Sprintf("%s() operation failed - Unmarshall Error. %s", caller, err)
+			fmt.Println(error_str)
+			return shim.Error(error_str)
+		}
+		objects = append(objects, object)
+	}
+
+	var tlist interface{} = objects
+	if objectType.ListView != nil {
+		tlist, err = objectType.ListView(stub, objects)
+		if err != nil {
+			return shim.Error(caller + " operation failed. " + err.Error())
+		}
+	}
+
+	jsonRows, err := MarshalList(tlist, len(objects), page)
+	if err != nil {
+		error_str := fmt.Sprintf("%s() operation failed - Marshall Error. %s", caller, err)
+		fmt.Println(error_str)
+		return shim.Error(error_str)
+	}
+
+	fmt.Println("List of ", objectType.Name, " Requested : ", jsonRows)
+	return shim.Success(jsonRows)
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"golang.org/x/crypto/ed25519"
+)
+
+// The MockStub has no submitter or transaction time, and MockInvoke hands the
+// chaincode the MockStub itself, so the transactions are run through testStub
+type testStub struct {
+	*shim.MockStub
+	function string
+	args     []string
+	creator  []byte
+	txTime   time.Time
+	nTx      int
+}
+
+func newTestStub(t *testing.T) *testStub {
+
+	stub := &testStub{
+		MockStub: shim.NewMockStub("trace", new(TraceChainCode)),
+		txTime:   time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC),
+	}
+	creator, err := proto.Marshal(&msp.SerializedIdentity{Mspid: "Org1MSP"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stub.creator = creator
+	if r := stub.transact("init"); r.Status != shim.OK {
+		t.Fatal("Init : " + r.Message)
+	}
+	return stub
+}
+
+func (stub *testStub) GetFunctionAndParameters() (string, []string) {
+	return stub.function, stub.args
+}
+
+func (stub *testStub) GetCreator() ([]byte, error) {
+	return stub.creator, nil
+}
+
+func (stub *testStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: stub.txTime.Unix()}, nil
+}
+
+// Run function as a transaction of its own, a minute after the previous one
+func (stub *testStub) transact(function string, args ...string) pb.Response {
+
+	stub.nTx++
+	stub.txTime = stub.txTime.Add(time.Minute)
+	stub.function, stub.args = function, args
+	txId := fmt.Sprintf("tx%d", stub.nTx)
+	stub.MockTransactionStart(txId)
+	defer stub.MockTransactionEnd(txId)
+
+	before := make(map[string][]byte, len(stub.State))
+	for key, value := range stub.State {
+		before[key] = value
+	}
+	var r pb.Response
+	if function == "init" {
+		r = new(TraceChainCode).Init(stub)
+	} else {
+		r = new(TraceChainCode).Invoke(stub)
+	}
+	if r.Status != shim.OK {
+		stub.rollback(before)
+	}
+	return r
+}
+
+// The MockStub writes at once, the writes of a failed transaction are taken
+// back here as the peer would never commit them
+func (stub *testStub) rollback(before map[string][]byte) {
+
+	for key := range stub.State {
+		if _, ok := before[key]; !ok {
+			stub.DelState(key)
+		}
+	}
+	for key, value := range before {
+		if !bytes.Equal(stub.State[key], value) {
+			stub.PutState(key, value)
+		}
+	}
+}
+
+func (stub *testStub) mustTransact(t *testing.T, function string, args ...string) []byte {
+
+	r := stub.transact(function, args...)
+	if r.Status != shim.OK {
+		t.Fatalf("%s%q : %d %s", function, args, r.Status, r.Message)
+	}
+	return r.Payload
+}
+
+// The stored value of an Object, nil if there is none
+func (stub *testStub) object(t *testing.T, objectType string, keys ...string) []byte {
+
+	compositeKey, err := stub.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stub.State[compositeKey]
+}
+
+func toJSON(t *testing.T, object interface{}) string {
+
+	buff, err := json.Marshal(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buff)
+}
+
+// An account registered with a fresh Ed25519 key, and that key
+type testSigner struct {
+	name string
+	key  ed25519.PrivateKey
+}
+
+func newTestSigner(t *testing.T, name string) testSigner {
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testSigner{name, key}
+}
+
+func (s testSigner) publicKey() string {
+	return base64.StdEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey))
+}
+
+func (s testSigner) sign(message []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, message))
+}
+
+func (s testSigner) account() AccountInfoObj {
+	return AccountInfoObj{s.name, "Farm", s.publicKey(), "Org1", "2017-01-01 00:00:00"}
+}
+
+func (s testSigner) certificationAccount() CertificationAccountInfoObj {
+	return CertificationAccountInfoObj{Name: s.name, AccountType: "Lab", PublicKey: s.publicKey(), OrgName: "Org1", TimeStamp: "2017-01-01 00:00:00"}
+}
+
+func (s testSigner) traceRecord(traceCode string, stationType string, preStation string) SkuTraceRecordObj {
+
+	rec := SkuTraceRecordObj{SkuId: "sku1", AddressHash: s.name, TraceCode: traceCode, StationType: stationType, BatchNum: "b1",
+		StationName: stationType, PreStation: preStation, BeginTime: "2017-05-01 00:00:00", TimeStamp: "2017-05-01 00:00:00"}
+	rec.Signature = s.sign(SkuTraceRecordSigningBytes(rec))
+	return rec
+}
+
+func (s testSigner) authenticationRecord(traceCode string) SkuAuthenticationTraceRecordObj {
+
+	rec := SkuAuthenticationTraceRecordObj{SkuId: "sku1", AddressHash: "farm", TraceCode: traceCode, CertificationBodyType: "Organic",
+		BatchNum: "b1", CertificationBodyName: s.name, BeginTime: "2017-01-01 00:00:00", EndTime: "2018-01-01 00:00:00", TimeStamp: "2017-05-01 00:00:00"}
+	rec.Signature = s.sign(SkuAuthenticationTraceRecordSigningBytes(rec))
+	return rec
+}
+
+func TestPostObjectTypes(t *testing.T) {
+
+	farm := newTestSigner(t, "farm")
+	lab := newTestSigner(t, "lab")
+	acc := farm.account()
+	cert := lab.certificationAccount()
+	rec := farm.traceRecord("tc1", "Factory", "")
+	auth := lab.authenticationRecord("tc1")
+	base := SkuBaseInfoObj{"sku1", "v1", "tc1", "farm", "Apples", "b1", "{}", "sig", "2017-05-01 00:00:00"}
+	trans := SkuTransactionObj{OrderId: "o1", SkuId: "sku1", TraceCode: "tc1", TransType: "Commission", BatchNum: "b1",
+		AccountNo: "a1", Num: "1", ExtJsonData: "{}", Signature: "sig", TransDate: "2017-05-01 00:00:00"}
+
+	registerSigners := func(t *testing.T, stub *testStub) {
+		stub.mustTransact(t, "iPostAccountInfo", toJSON(t, farm.account()))
+		stub.mustTransact(t, "iPostCertificationAccountInfo", toJSON(t, lab.certificationAccount()))
+	}
+
+	tests := []struct {
+		function   string
+		objectType string
+		keys       []string
+		object     interface{}
+		args       []string // the attributes of object in order
+		setup      func(t *testing.T, stub *testStub)
+	}{
+		{"iPostAccountInfo", "AccountInfoObj", []string{"farm"}, acc,
+			[]string{acc.Name, acc.AccountType, acc.PublicKey, acc.OrgName, acc.TimeStamp}, nil},
+		{"iPostCertificationAccountInfo", "CertificationAccountInfoObj", []string{"lab"}, cert,
+			[]string{cert.Name, cert.AccountType, cert.PublicKey, cert.OrgName, cert.TimeStamp}, nil},
+		{"iPostSkuBaseInfo", "SkuBaseInfoObj", []string{"tc1"}, base,
+			[]string{base.SkuId, base.VendorCode, base.TraceCode, base.AddressHash, base.Name, base.BatchNum, base.ExtJsonData, base.Signature, base.TimeStamp}, nil},
+		{"iPostTransactionId", "SkuBaseInfoObj", []string{"tc1"}, base,
+			[]string{base.SkuId, base.VendorCode, base.TraceCode, base.AddressHash, base.Name, base.BatchNum, base.ExtJsonData, base.Signature, base.TimeStamp}, nil},
+		{"iPostSkuTransaction", "SkuTransactionObj", []string{"tc1", "sku1", "o1", "Commission"}, trans,
+			[]string{trans.OrderId, trans.SkuId, trans.TraceCode, trans.TransType, trans.BatchNum, trans.AccountNo, trans.Num, trans.ExtJsonData, trans.Signature, trans.TransDate}, nil},
+		{"iPostSkuTraceRecord", "SkuTraceRecordObj", []string{"tc1", "sku1", "farm", "Factory"}, rec,
+			[]string{rec.SkuId, rec.AddressHash, rec.TraceCode, rec.StationType, rec.BatchNum, rec.StationName, rec.ExpressNum, rec.Signature,
+				rec.PreStation, rec.NextStation, rec.ExtJsonData, rec.BeginTime, rec.EndTime, rec.TimeStamp}, registerSigners},
+		{"iPostSkuAuthenticationTraceRecord", "SkuAuthenticationTraceRecordObj", []string{"tc1", "sku1", "farm", "Organic"}, auth,
+			[]string{auth.SkuId, auth.AddressHash, auth.TraceCode, auth.CertificationBodyType, auth.BatchNum, auth.CertificationBodyName,
+				auth.Signature, auth.ExtJsonData, auth.BeginTime, auth.EndTime, auth.TimeStamp}, registerSigners},
+	}
+
+	posted := map[string]bool{}
+	for _, objectType := range ObjectTypes() {
+		for fname, function := range objectType.Functions {
+			if function.Kind == OBJECT_POST {
+				posted[fname] = false
+			}
+		}
+	}
+
+	for _, test := range tests {
+		posted[test.function] = true
+		var stored [][]byte
+		for _, args := range [][]string{test.args, {toJSON(t, test.object)}} {
+			stub := newTestStub(t)
+			if test.setup != nil {
+				test.setup(t, stub)
+			}
+			payload := stub.mustTransact(t, test.function, args...)
+			value := stub.object(t, test.objectType, test.keys...)
+			if !bytes.Equal(payload, value) {
+				t.Errorf("%s%q : returned %s, stored %s", test.function, args, payload, value)
+			}
+			stored = append(stored, value)
+		}
+		if !bytes.Equal(stored[0], stored[1]) {
+			t.Errorf("%s : posted in order stored %s, as a JSON document %s", test.function, stored[0], stored[1])
+		}
+	}
+
+	for fname, tested := range posted {
+		if !tested {
+			t.Errorf("%s is not tested", fname)
+		}
+	}
+}
+
+func TestPatchKeepsKeys(t *testing.T) {
+
+	farm := newTestSigner(t, "farm")
+	stub := newTestStub(t)
+	stub.mustTransact(t, "iPostAccountInfo", toJSON(t, farm.account()))
+	rec := farm.traceRecord("tc1", "Factory", "")
+	stub.mustTransact(t, "iPostSkuTraceRecord", toJSON(t, rec))
+
+	moved := rec
+	moved.StationName = "Dock 2"
+	moved.Signature = farm.sign(SkuTraceRecordSigningBytes(moved))
+
+	keys := []string{"tc1", "sku1", "farm", "Factory"}
+	tests := []struct {
+		patch  string
+		status int32
+	}{
+		{`{"TraceCode":"tc2"}`, shim.ERROR},
+		{`{"traceCode":"tc2"}`, shim.ERROR},
+		{`{"StationType":"Warehouse","StationName":"Dock 2"}`, shim.ERROR},
+		{`{"TraceCode":"tc1","SkuId":"sku1"}`, shim.OK},
+		{`{"StationName":`, shim.ERROR},
+		{toJSON(t, map[string]string{"StationName": moved.StationName, "Signature": moved.Signature}), shim.OK},
+	}
+
+	for _, test := range tests {
+		r := stub.transact("iUpdateSkuTraceRecord", append(keys, test.patch)...)
+		if r.Status != test.status {
+			t.Errorf("patch %s : status %d, want %d : %s", test.patch, r.Status, test.status, r.Message)
+		}
+	}
+
+	var stored SkuTraceRecordObj
+	json.Unmarshal(stub.object(t, "SkuTraceRecordObj", keys...), &stored)
+	if stored != moved {
+		t.Errorf("stored %+v, want %+v", stored, moved)
+	}
+	if value := stub.object(t, "SkuTraceRecordObj", "tc2", "sku1", "farm", "Factory"); value != nil {
+		t.Errorf("a patch wrote the record under another key : %s", value)
+	}
+}
+
+// The Records and Tombstones of a paged list query
+type testListPage struct {
+	Records    []json.RawMessage
+	Bookmark   string
+	Tombstones []TombstoneObj
+}
+
+func TestListLeavesOutTombstones(t *testing.T) {
+
+	farm := newTestSigner(t, "farm")
+	stub := newTestStub(t)
+	stub.mustTransact(t, "iPostAccountInfo", toJSON(t, farm.account()))
+	for _, rec := range []SkuTraceRecordObj{
+		farm.traceRecord("tc1", "Factory", ""),
+		farm.traceRecord("tc1", "Warehouse", "Factory"),
+		farm.traceRecord("tc1", "Retail", "Warehouse"),
+	} {
+		stub.mustTransact(t, "iPostSkuTraceRecord", toJSON(t, rec))
+	}
+	stub.mustTransact(t, "iDeleteSkuTraceRecord", "tc1", "sku1", "farm", "Warehouse", "posted by mistake")
+
+	if r := stub.transact("iDeleteSkuTraceRecord", "tc1", "sku1", "farm", "Warehouse", "again"); r.Status != NOT_FOUND {
+		t.Errorf("deleting a deleted record : status %d, want %d", r.Status, NOT_FOUND)
+	}
+
+	tests := []struct {
+		args       []string
+		paged      bool
+		records    []string // StationType of the listed records
+		tombstones int
+	}{
+		{[]string{"tc1"}, false, []string{"Factory", "Retail"}, 0},
+		{[]string{"tc1", "includeDeleted"}, true, []string{"Factory", "Retail"}, 1},
+		{[]string{"tc1", "10"}, true, []string{"Factory", "Retail"}, 0},
+		{[]string{"tc1", "10", "includeDeleted"}, true, []string{"Factory", "Retail"}, 1},
+		{[]string{"tc1", "1"}, true, []string{"Factory"}, 0},
+		{[]string{"tc2"}, false, nil, 0},
+	}
+
+	for _, test := range tests {
+		payload := stub.mustTransact(t, "qGetSkuTraceRecordListByTraceCode", test.args...)
+		var page testListPage
+		var err error
+		if test.paged {
+			err = json.Unmarshal(payload, &page)
+		} else {
+			err = json.Unmarshal(payload, &page.Records)
+		}
+		if err != nil {
+			t.Fatalf("%q : %s : %s", test.args, err, payload)
+		}
+
+		var stations []string
+		for _, value := range page.Records {
+			var rec SkuTraceRecordObj
+			json.Unmarshal(value, &rec)
+			stations = append(stations, rec.StationType)
+		}
+		if strings.Join(stations, ",") != strings.Join(test.records, ",") {
+			t.Errorf("%q : listed %v, want %v", test.args, stations, test.records)
+		}
+		if len(page.Tombstones) != test.tombstones {
+			t.Errorf("%q : %d tombstones, want %d", test.args, len(page.Tombstones), test.tombstones)
+		}
+		for _, tombstone := range page.Tombstones {
+			if tombstone.Reason != "posted by mistake" || strings.Join(tombstone.Keys, ",") != "tc1,sku1,farm,Warehouse" {
+				t.Errorf("%q : tombstone %+v", test.args, tombstone)
+			}
+		}
+	}
+}
+
+func TestIndexesFollowWrites(t *testing.T) {
+
+	farm := newTestSigner(t, "farm")
+	stub := newTestStub(t)
+	stub.mustTransact(t, "iPostAccountInfo", toJSON(t, farm.account()))
+
+	rec := farm.traceRecord("tc1", "Factory", "")
+	rec.ExpressNum = "e1"
+	rec.Signature = farm.sign(SkuTraceRecordSigningBytes(rec))
+	shipped := rec
+	shipped.ExpressNum = "e2"
+	shipped.Signature = farm.sign(SkuTraceRecordSigningBytes(shipped))
+	base := SkuBaseInfoObj{SkuId: "sku1", TraceCode: "tc1", BatchNum: "b1", TimeStamp: "2017-05-01 00:00:00"}
+	rebatched := base
+	rebatched.BatchNum = "b2"
+
+	keys := []string{"tc1", "sku1", "farm", "Factory"}
+	type lookup struct {
+		args  []string // of qGetByIndex
+		count int
+	}
+	tests := []struct {
+		function string
+		args     []string
+		lookups  []lookup
+	}{
+		{"iPostSkuTraceRecord", []string{toJSON(t, rec)}, []lookup{
+			{[]string{"SkuTraceRecordObj", "ExpressNum", "e1"}, 1},
+			{[]string{"SkuTraceRecordObj", "SkuId", "sku1"}, 1},
+			{[]string{"SkuTraceRecordObj", "SkuId", "sku1", "b1"}, 1},
+			{[]string{"SkuTraceRecordObj", "SkuId", "sku1", "b2"}, 0},
+		}},
+		{"iUpdateSkuTraceRecord", append(keys, toJSON(t, map[string]string{"ExpressNum": "e2", "Signature": shipped.Signature})), []lookup{
+			{[]string{"SkuTraceRecordObj", "ExpressNum", "e1"}, 0},
+			{[]string{"SkuTraceRecordObj", "ExpressNum", "e2"}, 1},
+			{[]string{"SkuTraceRecordObj", "AddressHash", "farm"}, 1},
+		}},
+		{"iDeleteSkuTraceRecord", append(keys, "recalled"), []lookup{
+			{[]string{"SkuTraceRecordObj", "ExpressNum", "e2"}, 0},
+			{[]string{"SkuTraceRecordObj", "AddressHash", "farm"}, 0},
+		}},
+		{"iPostSkuBaseInfo", []string{toJSON(t, base)}, []lookup{
+			{[]string{"SkuBaseInfoObj", "BatchNum", "b1"}, 1},
+		}},
+		{"iUpdateSkuBaseInfo", []string{toJSON(t, rebatched)}, []lookup{
+			{[]string{"SkuBaseInfoObj", "BatchNum", "b1"}, 0},
+			{[]string{"SkuBaseInfoObj", "BatchNum", "b2"}, 1},
+			{[]string{"SkuBaseInfoObj", "SkuId", "sku1", "b2"}, 1},
+		}},
+	}
+
+	for _, test := range tests {
+		stub.mustTransact(t, test.function, test.args...)
+		for _, lookup := range test.lookups {
+			var found []json.RawMessage
+			payload := stub.mustTransact(t, "qGetByIndex", lookup.args...)
+			err := json.Unmarshal(payload, &found)
+			if err != nil {
+				t.Fatalf("after %s, qGetByIndex%q : %s : %s", test.function, lookup.args, err, payload)
+			}
+			if len(found) != lookup.count {
+				t.Errorf("after %s, qGetByIndex%q found %d, want %d : %s", test.function, lookup.args, len(found), lookup.count, payload)
+			}
+		}
+	}
+}
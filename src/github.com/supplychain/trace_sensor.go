@@ -0,0 +1,447 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"strconv"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////////////
+//
+// Cold-chain sensor readings
+//
+// Readings are appended in batches and kept per TraceCode and station. A reading
+// already on the ledger, or repeated in the batch, is skipped, so a batch can be sent again
+//     SensorReadingObj   : TraceCode, StationName, ReadAt, SensorId
+// Each SkuId can have limits on the ledger, an empty limit is not checked. A reading
+// names the SkuId of the SkuBaseInfoObj of its TraceCode, it is rejected otherwise
+//     SensorThresholdObj : SkuId
+// A reading outside the limits of its SkuId lists them in Violations, and sets the
+// violation flag of its TraceCode
+//     SensorViolationObj : TraceCode
+///////////////////////////////////////////////////////////////////////////////////////
+
+type SensorReadingObj struct {
+	TraceCode   string
+	SkuId       string
+	StationName string
+	SensorId    string
+	ReadAt      string
+	Temperature string // Degrees Celsius
+	Humidity    string // Percent relative humidity
+	ExtJsonData string
+	Violations  []string `json:",omitempty"` // Set by the chaincode
+}
+
+type SensorThresholdObj struct {
+	SkuId          string
+	MinTemperature string
+	MaxTemperature string
+	MinHumidity    string
+	MaxHumidity    string
+	TimeStamp      string // This is the time stamp
+}
+
+type SensorViolationObj struct {
+	TraceCode string
+	Count     string // Readings out of limits
+	FirstAt   string // ReadAt of the first one
+	LastAt    string // ReadAt of the last one
+}
+
+//////////////////////////////////////////////////////////
+// Readings and violations of a TraceCode
+//////////////////////////////////////////////////////////
+type SensorReportObj struct {
+	TraceCode string
+	Violation *SensorViolationObj
+	Readings  []SensorReadingObj
+	Bookmark  string `json:",omitempty"` // When a page was requested, see GetListByTraceCode
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Append sensor readings
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iPostSensorReadings", "Args":["[{\"TraceCode\":\"1111\",
+// \"SkuId\":\"SkuId\",\"StationName\":\"StationName\",\"SensorId\":\"SensorId\",\"ReadAt\":\"2017-06-01 10:00:00\",
+// \"Temperature\":\"4.5\",\"Humidity\":\"80\"}, ...]"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func PostSensorReadings(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 1 {
+		return shim.Error("PostSensorReadings(): Incorrect number of arguments. Expecting 1")
+	}
+	var raw []json.RawMessage
+	err := json.Unmarshal([]byte(args[0]), &raw)
+	if err != nil {
+		return shim.Error("PostSensorReadings(): Expecting a JSON array of SensorReadingObj : " + err.Error())
+	}
+
+	// Reads do not see the writes of the transaction, the flags are written once at the end
+	thresholds := map[string]*SensorThresholdObj{}
+	skuIds := map[string]string{} // by TraceCode
+	flags := map[string]*SensorViolationObj{}
+	posted := map[string]bool{}
+	var events []ObjectEvent
+	for i := range raw {
+		var reading SensorReadingObj
+		err = JSONtoObject("SensorReadingObj", raw[i], &reading, RequiredFields("SensorReadingObj"))
+		if err != nil {
+			return shim.Error(fmt.Sprintf("PostSensorReadings(): reading %d : %s", i, err))
+		}
+
+		// The threshold is that of the SkuId the TraceCode was registered with
+		skuId, ok := skuIds[reading.TraceCode]
+		if !ok {
+			base, err := LoadSkuBaseInfo(stub, reading.TraceCode)
+			if err != nil {
+				return shim.Error("PostSensorReadings(): " + err.Error())
+			}
+			if base == nil {
+				return NotFound(fmt.Sprintf("PostSensorReadings(): reading %d : no SkuBaseInfoObj for TraceCode %s", i, reading.TraceCode))
+			}
+			skuId = base.SkuId
+			skuIds[reading.TraceCode] = skuId
+		}
+		if reading.SkuId != skuId {
+			return shim.Error(fmt.Sprintf("PostSensorReadings(): reading %d : TraceCode %s is SkuId %s, not %s", i, reading.TraceCode, skuId, reading.SkuId))
+		}
+
+		// A reading is counted towards the violation flag once
+		keys := []string{reading.TraceCode, reading.StationName, reading.ReadAt, reading.SensorId}
+		if posted[strings.Join(keys, ",")] {
+			continue
+		}
+		posted[strings.Join(keys, ",")] = true
+		stored, err := QueryObject(stub, "SensorReadingObj", keys)
+		if err != nil {
+			return shim.Error("PostSensorReadings(): " + err.Error())
+		}
+		if stored != nil {
+			fmt.Println("PostSensorReadings() : Skipping reading already posted : ", keys)
+			continue
+		}
+
+		threshold, ok := thresholds[reading.SkuId]
+		if !ok {
+			threshold, err = GetSensorThreshold(stub, reading.SkuId)
+			if err != nil {
+				return shim.Error("PostSensorReadings(): " + err.Error())
+			}
+			thresholds[reading.SkuId] = threshold
+		}
+		reading.Violations, err = CheckSensorReading(reading, threshold)
+		if err != nil {
+			return shim.Error(fmt.Sprintf("PostSensorReadings(): reading %d : %s", i, err))
+		}
+
+		if len(reading.Violations) > 0 {
+			flag, ok := flags[reading.TraceCode]
+			if !ok {
+				flag, err = GetSensorViolation(stub, reading.TraceCode)
+				if err != nil {
+					return shim.Error("PostSensorReadings(): " + err.Error())
+				}
+				if flag == nil {
+					flag = &SensorViolationObj{TraceCode: reading.TraceCode, Count: "0"}
+				}
+				flags[reading.TraceCode] = flag
+			}
+			count, _ := strconv.Atoi(flag.Count)
+			flag.Count = strconv.Itoa(count + 1)
+			if flag.FirstAt == "" || reading.ReadAt < flag.FirstAt {
+				flag.FirstAt = reading.ReadAt
+			}
+			if reading.ReadAt > flag.LastAt {
+				flag.LastAt = reading.ReadAt
+			}
+		}
+
+		buff, err := json.Marshal(reading)
+		if err != nil {
+			return shim.Error("PostSensorReadings(): " + err.Error())
+		}
+		err = UpdateObject(stub, "SensorReadingObj", keys, buff)
+		if err != nil {
+			fmt.Println("PostSensorReadings() : write error while inserting record")
+			return shim.Error("PostSensorReadings() : write error while inserting record : Error - " + err.Error())
+		}
+		events = append(events, ObjectEvent{"SensorReadingObj", keys, buff})
+	}
+
+	for _, flag := range flags {
+		buff, err := json.Marshal(flag)
+		if err != nil {
+			return shim.Error("PostSensorReadings(): " + err.Error())
+		}
+		err = ReplaceObject(stub, "SensorViolationObj", []string{flag.TraceCode}, buff)
+		if err != nil {
+			return shim.Error("PostSensorReadings(): " + err.Error())
+		}
+	}
+
+	err = EmitObjectBatchEvent(stub, EVENT_SENSOR_READINGS_POSTED, "SensorReadingObj", events)
+	if err != nil {
+		return shim.Error("PostSensorReadings(): " + err.Error())
+	}
+	return shim.Success([]byte("OK"))
+}
+
+////////////////////////////////////////////////////////////////////////////
+// The limits a reading is outside of, none when there is no threshold
+////////////////////////////////////////////////////////////////////////////
+func CheckSensorReading(reading SensorReadingObj, threshold *SensorThresholdObj) ([]string, error) {
+
+	if reading.Temperature == "" && reading.Humidity == "" {
+		return nil, errors.New("a reading needs a Temperature or a Humidity")
+	}
+	values := []struct {
+		name  string
+		value string
+		min   string
+		max   string
+	}{{"Temperature", reading.Temperature, "", ""}, {"Humidity", reading.Humidity, "", ""}}
+	if threshold != nil {
+		values[0].min, values[0].max = threshold.MinTemperature, threshold.MaxTemperature
+		values[1].min, values[1].max = threshold.MinHumidity, threshold.MaxHumidity
+	}
+
+	var violations []string
+	for _, v := range values {
+		if v.value == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(v.value, 64)
+		if err != nil {
+			return nil, errors.New(v.name + " must be a number : " + v.value)
+		}
+		if v.min != "" {
+			min, _ := strconv.ParseFloat(v.min, 64)
+			if value < min {
+				violations = append(violations, v.name+" "+v.value+" below "+v.min)
+			}
+		}
+		if v.max != "" {
+			max, _ := strconv.ParseFloat(v.max, 64)
+			if value > max {
+				violations = append(violations, v.name+" "+v.value+" above "+v.max)
+			}
+		}
+	}
+	return violations, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Set the sensor limits of a SkuId
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iSetSensorThreshold", "Args":["{\"SkuId\":\"SkuId\",
+// \"MinTemperature\":\"0\",\"MaxTemperature\":\"8\",\"MaxHumidity\":\"90\"}"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func SetSensorThreshold(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 1 {
+		return shim.Error("SetSensorThreshold(): Incorrect number of arguments. Expecting 1")
+	}
+	var threshold SensorThresholdObj
+	err := JSONtoObject("SensorThresholdObj", []byte(args[0]), &threshold, RequiredFields("SensorThresholdObj"))
+	if err != nil {
+		return shim.Error("SetSensorThreshold(): " + err.Error())
+	}
+	limits := map[string]string{"MinTemperature": threshold.MinTemperature, "MaxTemperature": threshold.MaxTemperature,
+		"MinHumidity": threshold.MinHumidity, "MaxHumidity": threshold.MaxHumidity}
+	for name, limit := range limits {
+		if limit == "" {
+			continue
+		}
+		_, err = strconv.ParseFloat(limit, 64)
+		if err != nil {
+			return shim.Error("SetSensorThreshold(): " + name + " must be a number : " + limit)
+		}
+	}
+
+	now, err := GetTxTime(stub)
+	if err != nil {
+		return shim.Error("SetSensorThreshold(): " + err.Error())
+	}
+	threshold.TimeStamp = now.Format(TimeLayout)
+	buff, err := json.Marshal(threshold)
+	if err != nil {
+		return shim.Error("SetSensorThreshold(): " + err.Error())
+	}
+	keys := []string{threshold.SkuId}
+	err = ReplaceObject(stub, "SensorThresholdObj", keys, buff)
+	if err != nil {
+		fmt.Println("SetSensorThreshold() : write error while inserting record")
+		return shim.Error("SetSensorThreshold(): " + err.Error())
+	}
+	err = EmitObjectEvent(stub, EVENT_SENSOR_THRESHOLD_SET, "SensorThresholdObj", keys, buff)
+	if err != nil {
+		return shim.Error("SetSensorThreshold(): " + err.Error())
+	}
+	return shim.Success(buff)
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+// Get the sensor limits of a SkuId
+// peer chaincode query -l golang -n test_trace -c '{"Function": "qGetSensorThreshold", "Args": ["SkuId"]}' -o orderer0:7050
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+func GetSensorThresholdInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	Avalbytes, err := QueryObject(stub, "SensorThresholdObj", args)
+	if err != nil {
+		return shim.Error("GetSensorThresholdInfo(): " + err.Error())
+	}
+	if Avalbytes == nil {
+		return NotFound("GetSensorThresholdInfo(): no SensorThresholdObj for SkuId " + args[0])
+	}
+	return shim.Success(Avalbytes)
+}
+
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+// Get the sensor readings and the violation flag of a TraceCode
+// peer chaincode query -l golang -n test_trace -c '{"Function": "qGetSensorReadingsByTraceCode", "Args": ["1111"]}' -o orderer0:7050
+// or a page at a time, passing back the Bookmark of the previous page
+// peer chaincode query -l golang -n test_trace -c '{"Function": "qGetSensorReadingsByTraceCode", "Args": ["1111", "100", "<Bookmark>"]}' -o orderer0:7050
+/////////////////////////////////////////////////////////////////////////////////////////////////////
+func GetSensorReadingsByTraceCode(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	values, page, err := GetListByTraceCode(stub, "SensorReadingObj", args)
+	if err != nil {
+		return shim.Error("GetSensorReadingsByTraceCode() operation failed. " + err.Error())
+	}
+	report := SensorReportObj{TraceCode: args[0]}
+	if page != nil {
+		report.Bookmark = page.Bookmark
+	}
+	report.Readings, err = SensorReadingsFromValues(values)
+	if err != nil {
+		return shim.Error("GetSensorReadingsByTraceCode() operation failed - Unmarshall Error. " + err.Error())
+	}
+	report.Violation, err = GetSensorViolation(stub, args[0])
+	if err != nil {
+		return shim.Error("GetSensorReadingsByTraceCode() operation failed. " + err.Error())
+	}
+
+	buff, err := json.Marshal(report)
+	if err != nil {
+		return shim.Error("GetSensorReadingsByTraceCode() operation failed - Marshall Error. " + err.Error())
+	}
+	return shim.Success(buff)
+}
+
+//////////////////////////////////////////////////////////
+// All the readings and the violation flag of traceCode
+//////////////////////////////////////////////////////////
+func LoadSensorReport(stub shim.ChaincodeStubInterface, traceCode string) (SensorReportObj, error) {
+
+	report := SensorReportObj{TraceCode: traceCode}
+	values, err := GetListValues(stub, "SensorReadingObj", []string{traceCode})
+	if err != nil {
+		return report, err
+	}
+	report.Readings, err = SensorReadingsFromValues(values)
+	if err != nil {
+		return report, err
+	}
+	report.Violation, err = GetSensorViolation(stub, traceCode)
+	return report, err
+}
+
+func SensorReadingsFromValues(values [][]byte) ([]SensorReadingObj, error) {
+
+	var readings []SensorReadingObj
+	for _, value := range values {
+		var reading SensorReadingObj
+		err := json.Unmarshal(value, &reading)
+		if err != nil {
+			return nil, err
+		}
+		readings = append(readings, reading)
+	}
+	return readings, nil
+}
+
+//////////////////////////////////////////////////////////
+// The limits of skuId, nil if there are none
+//////////////////////////////////////////////////////////
+func GetSensorThreshold(stub shim.ChaincodeStubInterface, skuId string) (*SensorThresholdObj, error) {
+
+	Avalbytes, err := QueryObject(stub, "SensorThresholdObj", []string{skuId})
+	if err != nil || Avalbytes == nil {
+		return nil, err
+	}
+	var threshold SensorThresholdObj
+	err = json.Unmarshal(Avalbytes, &threshold)
+	if err != nil {
+		return nil, err
+	}
+	return &threshold, nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Count the violation flag of traceCode again from its readings, leaving out
+// the one under deletedKeys, deleted in this transaction. The flag is deleted
+// with reason when no reading is out of limits any more
+////////////////////////////////////////////////////////////////////////////
+func RecountSensorViolation(stub shim.ChaincodeStubInterface, traceCode string, deletedKeys []string, reason string) error {
+
+	flag, err := GetSensorViolation(stub, traceCode)
+	if err != nil || flag == nil {
+		return err
+	}
+	values, err := GetListValues(stub, "SensorReadingObj", []string{traceCode})
+	if err != nil {
+		return err
+	}
+	readings, err := SensorReadingsFromValues(values)
+	if err != nil {
+		return err
+	}
+
+	recount := SensorViolationObj{TraceCode: traceCode}
+	count := 0
+	for _, reading := range readings {
+		keys := []string{reading.TraceCode, reading.StationName, reading.ReadAt, reading.SensorId}
+		if len(reading.Violations) == 0 || strings.Join(keys, ",") == strings.Join(deletedKeys, ",") {
+			continue
+		}
+		count++
+		if recount.FirstAt == "" || reading.ReadAt < recount.FirstAt {
+			recount.FirstAt = reading.ReadAt
+		}
+		if reading.ReadAt > recount.LastAt {
+			recount.LastAt = reading.ReadAt
+		}
+	}
+	if count == 0 {
+		_, err = DeleteObject(stub, "SensorViolationObj", []string{traceCode}, reason)
+		return err
+	}
+	recount.Count = strconv.Itoa(count)
+	buff, err := json.Marshal(recount)
+	if err != nil {
+		return err
+	}
+	return ReplaceObject(stub, "SensorViolationObj", []string{traceCode}, buff)
+}
+
+//////////////////////////////////////////////////////////
+// The violation flag of traceCode, nil if it is not set
+//////////////////////////////////////////////////////////
+func GetSensorViolation(stub shim.ChaincodeStubInterface, traceCode string) (*SensorViolationObj, error) {
+
+	Avalbytes, err := QueryObject(stub, "SensorViolationObj", []string{traceCode})
+	if err != nil || Avalbytes == nil {
+		return nil, err
+	}
+	var flag SensorViolationObj
+	err = json.Unmarshal(Avalbytes, &flag)
+	if err != nil {
+		return nil, err
+	}
+	return &flag, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+func TestSensorReadingSkuId(t *testing.T) {
+
+	stub := newTestStub(t)
+	stub.mustTransact(t, "iPostSkuBaseInfo", toJSON(t, SkuBaseInfoObj{SkuId: "sku1", TraceCode: "tc1", BatchNum: "b1", TimeStamp: "2017-05-01 00:00:00"}))
+	stub.mustTransact(t, "iSetSensorThreshold", toJSON(t, SensorThresholdObj{SkuId: "sku1", MaxTemperature: "8"}))
+
+	reading := func(traceCode string, skuId string, readAt string, temperature string) SensorReadingObj {
+		return SensorReadingObj{TraceCode: traceCode, SkuId: skuId, StationName: "Truck", SensorId: "s1", ReadAt: readAt, Temperature: temperature}
+	}
+
+	tests := []struct {
+		name     string
+		readings []SensorReadingObj
+		status   int32
+		count    string // of the violation flag of tc1
+	}{
+		{"reading within limits", []SensorReadingObj{reading("tc1", "sku1", "2017-06-01 10:00:00", "4")}, shim.OK, ""},
+		{"reading out of limits", []SensorReadingObj{reading("tc1", "sku1", "2017-06-01 10:05:00", "10")}, shim.OK, "1"},
+		{"SkuId without a threshold", []SensorReadingObj{reading("tc1", "sku2", "2017-06-01 10:10:00", "10")}, shim.ERROR, "1"},
+		{"batch with another SkuId", []SensorReadingObj{reading("tc1", "sku1", "2017-06-01 10:15:00", "10"), reading("tc1", "sku2", "2017-06-01 10:20:00", "10")}, shim.ERROR, "1"},
+		{"unknown TraceCode", []SensorReadingObj{reading("tc2", "sku1", "2017-06-01 10:25:00", "10")}, NOT_FOUND, "1"},
+	}
+
+	for _, test := range tests {
+		r := stub.transact("iPostSensorReadings", toJSON(t, test.readings))
+		if r.Status != test.status {
+			t.Errorf("%s : status %d, want %d : %s", test.name, r.Status, test.status, r.Message)
+		}
+		var report SensorReportObj
+		payload := stub.mustTransact(t, "qGetSensorReadingsByTraceCode", "tc1")
+		err := json.Unmarshal(payload, &report)
+		if err != nil {
+			t.Fatalf("qGetSensorReadingsByTraceCode : %s : %s", err, payload)
+		}
+		count := ""
+		if report.Violation != nil {
+			count = report.Violation.Count
+		}
+		if count != test.count {
+			t.Errorf("%s : violation count %q, want %q", test.name, count, test.count)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////////////
+//
+// Channel settings
+//
+// Each channel runs its own instance of the chaincode with its own ledger, so a
+// setting kept on the ledger applies to one channel. A setting that was never set
+// has the value of the Switches of the configuration, see trace_config.go, or else
+// its Default value. Only an admin of the configuration can change a setting
+// peer chaincode invoke -n test_trace -c '{"Function": "iSetChannelSetting", "Args":["StationContinuity", "reject"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////
+
+type ChannelSettingObj struct {
+	Name      string
+	Value     string
+	TimeStamp string // This is the time stamp
+}
+
+type ChannelSetting struct {
+	Default  string
+	Validate func(value string) error
+}
+
+//////////////////////////////////////////////////////////
+// The settings a channel can change
+//////////////////////////////////////////////////////////
+var ChannelSettings = map[string]ChannelSetting{
+	SETTING_STATION_CONTINUITY: {CONTINUITY_FLAG, OneOf(CONTINUITY_FLAG, CONTINUITY_REJECT)},
+	SETTING_TRANSFER_TIMEOUT:   {"72h", PositiveDuration},
+}
+
+//////////////////////////////////////////////////////////
+// Validator accepting only the values listed
+//////////////////////////////////////////////////////////
+func OneOf(values ...string) func(value string) error {
+	return func(value string) error {
+		for _, v := range values {
+			if v == value {
+				return nil
+			}
+		}
+		return errors.New("value must be one of " + strings.Join(values, ", "))
+	}
+}
+
+//////////////////////////////////////////////////////////
+// The value of setting name on this channel
+//////////////////////////////////////////////////////////
+func GetChannelSetting(stub shim.ChaincodeStubInterface, name string) (string, error) {
+
+	setting, ok := ChannelSettings[name]
+	if !ok {
+		return "", errors.New("Unknown channel setting " + name)
+	}
+	Avalbytes, err := QueryObject(stub, "ChannelSettingObj", []string{name})
+	if err != nil {
+		return "", err
+	}
+	if Avalbytes == nil {
+		// Not changed on this channel, the value of the configuration if any
+		config, err := GetChaincodeConfig(stub)
+		if err != nil {
+			return "", err
+		}
+		if value, ok := config.switchValue(name); ok {
+			return value, nil
+		}
+		return setting.Default, nil
+	}
+	var obj ChannelSettingObj
+	err = json.Unmarshal(Avalbytes, &obj)
+	if err != nil {
+		return "", err
+	}
+	return obj.Value, nil
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+// Change a channel setting, only an admin of the configuration may, see trace_config.go
+// peer chaincode invoke -l golang -n test_trace -c '{"Function": "iSetChannelSetting", "Args":["Name", "Value"]}' -o orderer0:7050
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+func SetChannelSetting(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 2 {
+		return shim.Error("SetChannelSetting(): Incorrect number of arguments. Expecting Name and Value")
+	}
+	_, err := AuthorizeAdmin(stub)
+	if err != nil {
+		return shim.Error("SetChannelSetting(): " + err.Error())
+	}
+	setting, ok := ChannelSettings[args[0]]
+	if !ok {
+		return shim.Error("SetChannelSetting(): Unknown channel setting " + args[0])
+	}
+	err = setting.Validate(args[1])
+	if err != nil {
+		return shim.Error("SetChannelSetting(): " + args[0] + " : " + err.Error())
+	}
+
+	now, err := GetTxTime(stub)
+	if err != nil {
+		return shim.Error("SetChannelSetting(): " + err.Error())
+	}
+	buff, err := json.Marshal(ChannelSettingObj{args[0], args[1], now.Format(TimeLayout)})
+	if err != nil {
+		return shim.Error("SetChannelSetting(): " + err.Error())
+	}
+	keys := []string{args[0]}
+	err = ReplaceObject(stub, "ChannelSettingObj", keys, buff)
+	if err != nil {
+		fmt.Println("SetChannelSetting() : write error while inserting record")
+		return shim.Error("SetChannelSetting(): " + err.Error())
+	}
+	err = EmitObjectEvent(stub, EVENT_CHANNEL_SETTING_SET, "ChannelSettingObj", keys, buff)
+	if err != nil {
+		return shim.Error("SetChannelSetting(): " + err.Error())
+	}
+	return shim.Success(buff)
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////
+// Retrieve a channel setting, with the value of the configuration or its Default if it was never set
+// peer chaincode query -n test_trace -c '{"Function": "qGetChannelSetting","Args":["StationContinuity"]}' -o orderer0:7050
+//////////////////////////////////////////////////////////////////////////////////////////
+func GetChannelSettingInfo(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+
+	if len(args) != 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+	value, err := GetChannelSetting(stub, args[0])
+	if err != nil {
+		return shim.Error("GetChannelSettingInfo(): " + err.Error())
+	}
+	buff, err := json.Marshal(ChannelSettingObj{Name: args[0], Value: value})
+	if err != nil {
+		return shim.Error("GetChannelSettingInfo(): " + err.Error())
+	}
+	return shim.Success(buff)
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"golang.org/x/crypto/ed25519"
+	"math/big"
+	"strings"
+)
+
+///////////////////////////////////////////////////////////////////////////////////////
+//
+// Record signatures
+//
+// A record is signed over its canonical encoding, the JSON array of its attribute
+// values in declaration order with the Signature attribute left out
+//
+//     ["SkuId","AddressHash","TraceCode","StationType","BatchNum","StationName","ExpressNum",
+//      "PreStation","NextStation","ExtJsonData","BeginTime","EndTime","TimeStamp"]
+//
+// and for a SkuAuthenticationTraceRecordObj, signed by the CertificationAccountInfoObj
+// registered as CertificationBodyName
+//
+//     ["SkuId","AddressHash","TraceCode","CertificationBodyType","BatchNum","CertificationBodyName",
+//      "ExtJsonData","BeginTime","EndTime","TimeStamp"]
+//
+// Supported PublicKey formats
+//     ECDSA P-256 : PEM (PUBLIC KEY or CERTIFICATE), or the base64/hex encoded PKIX DER or
+//                   65 byte uncompressed point. The signature is over the SHA-256 digest of
+//                   the canonical encoding, ASN.1 DER or 64 byte r||s
+//     Ed25519     : PEM (PUBLIC KEY), or the base64/hex encoded PKIX DER or 32 byte key.
+//                   The signature is over the canonical encoding itself
+// Signatures are base64 or hex encoded
+///////////////////////////////////////////////////////////////////////////////////////
+
+var (
+	ErrSignatureMissing               = errors.New("SIGNATURE_MISSING")
+	ErrSignerNotRegistered            = errors.New("SIGNER_NOT_REGISTERED")
+	ErrCertificationBodyNotRegistered = errors.New("CERTIFICATION_BODY_NOT_REGISTERED")
+	ErrUnsupportedPublicKey           = errors.New("UNSUPPORTED_PUBLIC_KEY")
+	ErrMalformedSignature             = errors.New("MALFORMED_SIGNATURE")
+	ErrSignatureInvalid               = errors.New("SIGNATURE_INVALID")
+)
+
+//////////////////////////////////////////////////////////
+// Error returned when a record can not be verified
+// Err is one of the Err* values above
+//////////////////////////////////////////////////////////
+type SignatureError struct {
+	Err    error
+	Signer string
+	Detail string
+}
+
+func (e *SignatureError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("%s : signer %s", e.Err, e.Signer)
+	}
+	return fmt.Sprintf("%s : signer %s : %s", e.Err, e.Signer, e.Detail)
+}
+
+func (e *SignatureError) Unwrap() error {
+	return e.Err
+}
+
+//////////////////////////////////////////////////////////
+// Canonical encoding of a SkuTraceRecordObj
+//////////////////////////////////////////////////////////
+func SkuTraceRecordSigningBytes(rec SkuTraceRecordObj) []byte {
+
+	values := []string{rec.SkuId, rec.AddressHash, rec.TraceCode, rec.StationType, rec.BatchNum, rec.StationName,
+		rec.ExpressNum, rec.PreStation, rec.NextStation, rec.ExtJsonData, rec.BeginTime, rec.EndTime, rec.TimeStamp}
+	buff, _ := json.Marshal(values)
+	return buff
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Verify the Signature of a SkuTraceRecordObj against the PublicKey of the
+// AccountInfoObj registered for its AddressHash, the one valid at the record
+// TimeStamp. This holds for a stored record after its key was rotated, a
+// record being written is checked with VerifyPostedSkuTraceRecordSignature
+////////////////////////////////////////////////////////////////////////////
+func VerifySkuTraceRecordSignature(stub shim.ChaincodeStubInterface, rec SkuTraceRecordObj) error {
+
+	if rec.Signature == "" {
+		return &SignatureError{Err: ErrSignatureMissing, Signer: rec.AddressHash}
+	}
+
+	publicKey, err := GetAccountPublicKey(stub, rec.AddressHash, rec.TimeStamp)
+	if err != nil {
+		return err
+	}
+
+	return VerifySignature(rec.AddressHash, publicKey, SkuTraceRecordSigningBytes(rec), rec.Signature)
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Verify the Signature of a SkuTraceRecordObj being written. The TimeStamp
+// is chosen by the signer, so the key valid at the TimeStamp must still be
+// the one valid at the transaction time: a rotated out key can not sign by
+// backdating a record
+////////////////////////////////////////////////////////////////////////////
+func VerifyPostedSkuTraceRecordSignature(stub shim.ChaincodeStubInterface, rec SkuTraceRecordObj) error {
+
+	now, err := GetTxTime(stub)
+	if err != nil {
+		return err
+	}
+	current, err := GetAccountPublicKey(stub, rec.AddressHash, now.Format(TimeLayout))
+	if err != nil {
+		return err
+	}
+	publicKey, err := GetAccountPublicKey(stub, rec.AddressHash, rec.TimeStamp)
+	if err != nil {
+		return err
+	}
+	if publicKey != current {
+		return &SignatureError{Err: ErrNoValidKey, Signer: rec.AddressHash, Detail: "the key valid at " + rec.TimeStamp + " is not valid at the transaction time"}
+	}
+	return VerifySkuTraceRecordSignature(stub, rec)
+}
+
+//////////////////////////////////////////////////////////
+// Canonical encoding of a SkuAuthenticationTraceRecordObj
+//////////////////////////////////////////////////////////
+func SkuAuthenticationTraceRecordSigningBytes(rec SkuAuthenticationTraceRecordObj) []byte {
+
+	values := []string{rec.SkuId, rec.AddressHash, rec.TraceCode, rec.CertificationBodyType, rec.BatchNum,
+		rec.CertificationBodyName, rec.ExtJsonData, rec.BeginTime, rec.EndTime, rec.TimeStamp}
+	buff, _ := json.Marshal(values)
+	return buff
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Verify the Signature of a SkuAuthenticationTraceRecordObj against the
+// PublicKey of the CertificationAccountInfoObj named CertificationBodyName,
+// which must not be revoked or suspended
+////////////////////////////////////////////////////////////////////////////
+func VerifySkuAuthenticationTraceRecordSignature(stub shim.ChaincodeStubInterface, rec SkuAuthenticationTraceRecordObj) error {
+
+	if rec.Signature == "" {
+		return &SignatureError{Err: ErrSignatureMissing, Signer: rec.CertificationBodyName}
+	}
+
+	Avalbytes, err := QueryObject(stub, "CertificationAccountInfoObj", []string{rec.CertificationBodyName})
+	if err != nil {
+		return err
+	}
+	if Avalbytes == nil {
+		return &SignatureError{Err: ErrCertificationBodyNotRegistered, Signer: rec.CertificationBodyName}
+	}
+	acc, err := JSONtoCertificationAccountInfoObj(Avalbytes)
+	if err != nil {
+		return err
+	}
+
+	err = VerifySignature(rec.CertificationBodyName, acc.PublicKey, SkuAuthenticationTraceRecordSigningBytes(rec), rec.Signature)
+	if err != nil {
+		return err
+	}
+
+	// A revoked or suspended body can not stamp, neither now nor at the record TimeStamp
+	now, err := GetTxTime(stub)
+	if err != nil {
+		return err
+	}
+	for _, timeStamp := range []string{now.Format(TimeLayout), rec.TimeStamp} {
+		if change := CertificationStatusAt(acc, timeStamp); change != nil {
+			return CertificationStatusError(rec.CertificationBodyName, change)
+		}
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Returns the PublicKey an AccountInfoObj signs with at timeStamp, from its
+// key history, see trace_keys.go
+////////////////////////////////////////////////////////////////////////////
+func GetAccountPublicKey(stub shim.ChaincodeStubInterface, name string, timeStamp string) (string, error) {
+
+	acc, err := GetAccountInfoObj(stub, name)
+	if err != nil {
+		return "", err
+	}
+	if acc == nil {
+		return "", &SignatureError{Err: ErrSignerNotRegistered, Signer: name}
+	}
+
+	keys, err := LoadAccountKeys(stub, *acc)
+	if err != nil {
+		return "", err
+	}
+	key := AccountKeyAt(keys, timeStamp)
+	if key == nil {
+		return "", &SignatureError{Err: ErrNoValidKey, Signer: name, Detail: "no key valid at " + timeStamp}
+	}
+	return key.PublicKey, nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Verify signature over message with publicKey
+////////////////////////////////////////////////////////////////////////////
+func VerifySignature(signer string, publicKey string, message []byte, signature string) error {
+
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return &SignatureError{Err: ErrUnsupportedPublicKey, Signer: signer, Detail: err.Error()}
+	}
+
+	sig, err := decodeBinary(signature)
+	if err != nil {
+		return &SignatureError{Err: ErrMalformedSignature, Signer: signer, Detail: err.Error()}
+	}
+
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		if len(sig) != ed25519.SignatureSize {
+			return &SignatureError{Err: ErrMalformedSignature, Signer: signer, Detail: "Ed25519 signature must be 64 bytes"}
+		}
+		if !ed25519.Verify(k, message, sig) {
+			return &SignatureError{Err: ErrSignatureInvalid, Signer: signer}
+		}
+	case *ecdsa.PublicKey:
+		r, s, err := parseECDSASignature(sig)
+		if err != nil {
+			return &SignatureError{Err: ErrMalformedSignature, Signer: signer, Detail: err.Error()}
+		}
+		digest := sha256.Sum256(message)
+		if !ecdsa.Verify(k, digest[:], r, s) {
+			return &SignatureError{Err: ErrSignatureInvalid, Signer: signer}
+		}
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////////////////
+// Parse a PublicKey attribute into an ECDSA P-256 or Ed25519 key
+////////////////////////////////////////////////////////////////////////////
+func ParsePublicKey(publicKey string) (interface{}, error) {
+
+	var key interface{}
+	if block, _ := pem.Decode([]byte(publicKey)); block != nil {
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			key, err = parsePKIXPublicKey(cert.RawSubjectPublicKeyInfo)
+			if err != nil {
+				return nil, err
+			}
+		case "PUBLIC KEY":
+			pub, err := parsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			key = pub
+		default:
+			return nil, errors.New("unsupported PEM block " + block.Type)
+		}
+	} else {
+		der, err := decodeBinary(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case len(der) == ed25519.PublicKeySize:
+			key = ed25519.PublicKey(der)
+		case len(der) == 65 && der[0] == 4:
+			x, y := elliptic.Unmarshal(elliptic.P256(), der)
+			if x == nil {
+				return nil, errors.New("invalid P-256 point")
+			}
+			key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		default:
+			pub, err := parsePKIXPublicKey(der)
+			if err != nil {
+				return nil, err
+			}
+			key = pub
+		}
+	}
+
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		return k, nil
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, errors.New("ECDSA key is not on curve P-256")
+		}
+		return k, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", key)
+}
+
+// Object identifier of Ed25519 keys, RFC 8410
+var oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+// Parse a DER SubjectPublicKeyInfo. crypto/x509 knows Ed25519 keys from Go 1.13 on only,
+// and older peers build the chaincode too, so those are read here
+func parsePKIXPublicKey(der []byte) (interface{}, error) {
+
+	var info struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	rest, err := asn1.Unmarshal(der, &info)
+	if err == nil && len(rest) == 0 && info.Algorithm.Algorithm.Equal(oidEd25519) {
+		if len(info.PublicKey.Bytes) != ed25519.PublicKeySize {
+			return nil, errors.New("Ed25519 public key must be 32 bytes")
+		}
+		return ed25519.PublicKey(info.PublicKey.Bytes), nil
+	}
+	return x509.ParsePKIXPublicKey(der)
+}
+
+func parseECDSASignature(sig []byte) (*big.Int, *big.Int, error) {
+
+	if len(sig) == 64 {
+		return new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]), nil
+	}
+	var rs struct {
+		R, S *big.Int
+	}
+	rest, err := asn1.Unmarshal(sig, &rs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rest) != 0 || rs.R == nil || rs.S == nil || rs.R.Sign() <= 0 || rs.S.Sign() <= 0 {
+		return nil, nil, errors.New("invalid ECDSA signature encoding")
+	}
+	return rs.R, rs.S, nil
+}
+
+// Decode a hex or base64 encoded value
+func decodeBinary(value string) ([]byte, error) {
+
+	value = strings.TrimSpace(value)
+	if b, err := hex.DecodeString(value); err == nil {
+		return b, nil
+	}
+	if b, err := base64.StdEncoding.DecodeString(value); err == nil {
+		return b, nil
+	}
+	if b, err := base64.RawStdEncoding.DecodeString(value); err == nil {
+		return b, nil
+	}
+	if b, err := base64.URLEncoding.DecodeString(value); err == nil {
+		return b, nil
+	}
+	return base64.RawURLEncoding.DecodeString(value)
+}
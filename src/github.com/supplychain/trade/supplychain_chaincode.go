@@ -17,7 +17,8 @@ limitations under the License.
 package main
 
 import (
-	"bytes"
+	"encoding/json"
+	"errors"
 	//"fmt"
     //"os" 
 	"strconv"
@@ -30,6 +31,9 @@ import (
 
 var logger = shim.NewLogger("supplychain")
 
+// Layout of the TradeDate value, that of time.Time.String()
+const tradeDateLayout = "2006-01-02 15:04:05 -0700 MST"
+
 // Supply chain Chaincode implementation
 type SupplyChaincode struct {
 }
@@ -64,7 +68,7 @@ func (t *SupplyChaincode) Query(stub shim.ChaincodeStubInterface) pb.Response {
 }
 
 
-// Transaction include addNewTrade, queryTrade, and (TODO) getTradeHistory
+// Transaction include addNewTrade, queryTrade, and getTradeHistory
 func (t *SupplyChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
     
     logger.Notice("########### supplychain_chaincode Invoke ###########")
@@ -106,8 +110,13 @@ func (t *SupplyChaincode) addNewTrade(stub shim.ChaincodeStubInterface, args []s
 	}
 
 	// Initialize the chaincode
+	// The transaction time, the same on every endorsing peer
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	TradeDate = "TradeDate"
-	TradeDateVal = time.Unix(time.Now().Unix(), 0).String()
+	TradeDateVal = time.Unix(txTimestamp.Seconds, 0).UTC().Format(tradeDateLayout)
 	Sku = args[0]
 	SkuVal = args[1]	
 	TraceInfo = args[2]
@@ -201,37 +210,125 @@ func (t *SupplyChaincode) queryTrade(stub shim.ChaincodeStubInterface, args []st
 }
 
 
+// Options of getTradeHistory, either positional
+//     ["nameOfTxId", "queriedKey", ...]
+// or a single JSON document, From and To ("2006-01-02 15:04:05", UTC) bound the
+// transaction time of the entries returned and may be left out
+//     ["{\"TxIdName\":\"TxId\",\"Keys\":[\"Sku\",\"TraceInfo\"],\"From\":\"2017-07-01 00:00:00\",\"To\":\"2017-08-01 00:00:00\"}"]
+type tradeHistoryRequest struct {
+	TxIdName string
+	Keys     []string
+	From     string
+	To       string
+}
+
+// Layout of the transaction time of a historic value
+const historyTimeLayout = "2006-01-02 15:04:05"
+
 // Query all fields of historic state
+// Each entry of the JSON array carries the transaction id under nameOfTxId, the value under
+// the queried key, and Key, Timestamp and IsDelete
+//     [{"TxId":"...","Sku":"...","Key":"Sku","Timestamp":"2017-07-14 02:40:00","IsDelete":false}, ...]
 func (t *SupplyChaincode) getTradeHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var nameOfTxId, queriedKey string	// Fileds of a trade
 	var err error
 
     logger.Info("########### supplychain_chaincode getTradeHistory ###########")
 	printArgs(args)
 
-	if len(args) < 2 {
-		return shim.Error("Incorrect number of arguments. Expecting nameOfTxId and at least 1 queriedKey")
-	} else {
-		nameOfTxId = args[0]
-		queriedKey = args[1]
+	request, err := parseTradeHistoryRequest(args)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
 
-	resultsIterator, err := stub.GetHistoryForKey(queriedKey)
+	var from, to time.Time
+	if request.From != "" {
+		from, err = time.Parse(historyTimeLayout, request.From)
+		if err != nil {
+			return shim.Error("From is not a valid time : " + request.From)
+		}
+	}
+	if request.To != "" {
+		to, err = time.Parse(historyTimeLayout, request.To)
+		if err != nil {
+			return shim.Error("To is not a valid time : " + request.To)
+		}
+	}
+
+	txTimes := map[string]time.Time{}
+	entries := []map[string]interface{}{}
+	for _, queriedKey := range request.Keys {
+		history, err := readHistory(stub, queriedKey)
+		if err != nil {
+			logger.Errorf("ERROR: error in reading the history of %s : %s", queriedKey, err)
+			return shim.Error("Failed to read the history of " + queriedKey + " : " + err.Error())
+		}
+
+		for _, version := range history {
+			txTime, err := tradeTime(stub, txTimes, version.txID)
+			if err != nil {
+				return shim.Error("Failed to read the transaction time of " + version.txID + " : " + err.Error())
+			}
+			if (request.From != "" || request.To != "") && txTime.IsZero() {
+				continue
+			}
+			if request.From != "" && txTime.Before(from) {
+				continue
+			}
+			if request.To != "" && txTime.After(to) {
+				continue
+			}
+			entries = append(entries, formatHistoricValue(request.TxIdName, queriedKey, version, txTime))
+		}
+	}
+
+	buffer, err := json.Marshal(entries)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
-	defer resultsIterator.Close()
-
-	// buffer is a JSON array containing historic values
-	var buffer bytes.Buffer
-	buffer = formatHistoricValue(buffer, resultsIterator, nameOfTxId, queriedKey)
 	// TODO: should change to Debugf when loglevel bug fixed in fabric
-	//logger.Debugf("queryTrade returning:\n%s\n", buffer.String())
-	logger.Infof("queryTrade returning:\n%s\n", buffer.String())
+	//logger.Debugf("queryTrade returning:\n%s\n", buffer)
+	logger.Infof("getTradeHistory returning:\n%s\n", buffer)
+
+	return shim.Success(buffer)
+}
 
-	return shim.Success(buffer.Bytes())
+func parseTradeHistoryRequest(args []string) (tradeHistoryRequest, error) {
+	var request tradeHistoryRequest
+
+	if len(args) == 1 && strings.HasPrefix(strings.TrimSpace(args[0]), "{") {
+		err := json.Unmarshal([]byte(args[0]), &request)
+		if err != nil {
+			return request, errors.New("Invalid JSON request : " + err.Error())
+		}
+		if request.TxIdName == "" {
+			request.TxIdName = "TxId"
+		}
+	} else if len(args) >= 2 {
+		request.TxIdName = args[0]
+		request.Keys = args[1:]
+	}
+
+	if len(request.Keys) == 0 {
+		return request, errors.New("Incorrect number of arguments. Expecting nameOfTxId and at least 1 queriedKey")
+	}
+
+	// nameOfTxId and the queried keys name attributes of the entries, next to these
+	for _, name := range append([]string{request.TxIdName}, request.Keys...) {
+		if historyEntryFields[name] {
+			return request, errors.New(name + " can not be used as nameOfTxId or queriedKey, it is an attribute of every entry")
+		}
+	}
+	for _, queriedKey := range request.Keys {
+		if queriedKey == request.TxIdName {
+			return request, errors.New(queriedKey + " can not be both nameOfTxId and a queriedKey")
+		}
+	}
+	return request, nil
 }
 
+// Attributes formatHistoricValue sets on every entry
+var historyEntryFields = map[string]bool{"Key": true, "Timestamp": true, "IsDelete": true}
+
 
 // Generat fake TransactionId
 /*
@@ -245,43 +342,67 @@ func getUUID() (string) {
 }*/
 
 
+// A historic value of a key, value is empty when the key was deleted
+type historicValue struct {
+	txID  string
+	value []byte
+}
 
-// Format historic values into string of JSON array
-func formatHistoricValue(buffer bytes.Buffer, resultsIterator shim.StateQueryIteratorInterface, nameOfTxId string, queriedKey string) bytes.Buffer {
-	// Start of an array
-	buffer.WriteString("[")
+// Read the whole history of queriedKey. An iterator failure fails the read,
+// a partial history is never returned
+func readHistory(stub shim.ChaincodeStubInterface, queriedKey string) ([]historicValue, error) {
+	resultsIterator, err := stub.GetHistoryForKey(queriedKey)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
 
-	bArrayMemberAlreadyWritten := false
+	var history []historicValue
 	for resultsIterator.HasNext() {
-		txID, historicValue, err := resultsIterator.Next()
+		txID, value, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, historicValue{txID, value})
+	}
+	return history, nil
+}
+
+// The time of the transaction txID. Every addNewTrade writes TradeDate, so its history
+// holds the time of each trade transaction. Zero when txID did not write TradeDate
+func tradeTime(stub shim.ChaincodeStubInterface, txTimes map[string]time.Time, txID string) (time.Time, error) {
+	if len(txTimes) == 0 {
+		history, err := readHistory(stub, "TradeDate")
 		if err != nil {
-			logger.Error("ERROR: error in reading resultsIterator, write nothing to buffer and returning... ") //error
-			return buffer
+			return time.Time{}, err
 		}
-		// Add a comma before array members
-		// then it will be parsed in query client
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
+		for _, version := range history {
+			tradeDate, err := time.Parse(tradeDateLayout, string(version.value))
+			if err != nil {
+				logger.Warningf("Ignoring TradeDate %s of %s : %s", version.value, version.txID, err)
+				continue
+			}
+			txTimes[version.txID] = tradeDate.UTC()
 		}
-		buffer.WriteString("{\"")
-		buffer.WriteString(nameOfTxId)
-		buffer.WriteString("\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(txID)
-		buffer.WriteString("\"")
-
-		buffer.WriteString(",\"")
-		buffer.WriteString(queriedKey)
-		buffer.WriteString("\":")
-		buffer.WriteString("\"")
-		buffer.WriteString(string(historicValue))
-		buffer.WriteString("\"")
-		buffer.WriteString("}")
-		bArrayMemberAlreadyWritten = true
+		// Remember the history was read, even when it is empty
+		txTimes[""] = time.Time{}
 	}
-	buffer.WriteString("]")
-	
-	return buffer
+	return txTimes[txID], nil
+}
+
+// Format a historic value as an entry of the JSON array returned by getTradeHistory
+func formatHistoricValue(nameOfTxId string, queriedKey string, version historicValue, txTime time.Time) map[string]interface{} {
+	entry := map[string]interface{}{
+		nameOfTxId: version.txID,
+		queriedKey: string(version.value),
+	}
+	entry["Key"] = queriedKey
+	entry["IsDelete"] = len(version.value) == 0
+	entry["Timestamp"] = ""
+	if !txTime.IsZero() {
+		entry["Timestamp"] = txTime.Format(historyTimeLayout)
+	}
+	return entry
 }
 
 